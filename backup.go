@@ -0,0 +1,366 @@
+package dbsdk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// A backup archive is a gzip stream of
+//
+//	magic "ADBK" and a version byte
+//	uvarint length and the JSON BackupHeader
+//	blocks of rows, each uvarint row count, uvarint size, the rows and a crc32c of the rows
+//	a zero row count, the number of rows as a big endian uint64 and a sha256 of everything before it
+//
+// every row is a uvarint length followed by a proto encoded DBTuple
+// A block is what Restore sends in one AppendBatch, so it is checked before anything is written
+const backupVersion = 1
+
+const (
+	backupBlockRows  = 1000
+	backupBlockBytes = 1 << 20
+)
+
+var (
+	backupMagic = []byte("ADBK")
+	backupCRC   = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrBadBackup is returned by Restore for an archive that is not a backup or is damaged
+	ErrBadBackup = errors.New("invalid backup archive")
+)
+
+// BackupHeader describes the table a backup archive was taken from
+// The stats are read when the backup starts
+type BackupHeader struct {
+	Version  int       `json:"version"`
+	Table    string    `json:"table"`
+	RowCount int64     `json:"rowCount"`
+	OldestTS int64     `json:"oldestTs"`
+	NewestTS int64     `json:"newestTs"`
+	Created  time.Time `json:"created"`
+}
+
+// Backup will write every row of table to w as a compressed, checksummed archive
+// The rows are the ones up to the newest row when the backup starts, rows appended
+// while it runs are left out unless they are older than that
+func (s *AppendDbSDKClient) Backup(ctx context.Context, table string, w io.Writer) error {
+	stats, err := s.StatsContext(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	bw := &backupWriter{w: bufio.NewWriterSize(zw, 1<<16), sum: sha256.New()}
+
+	header, err := json.Marshal(BackupHeader{
+		Version:  backupVersion,
+		Table:    table,
+		RowCount: stats.RowCount,
+		OldestTS: stats.OldestTS,
+		NewestTS: stats.NewestTS,
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	bw.write(backupMagic)
+	bw.write([]byte{backupVersion})
+	bw.uvarint(uint64(len(header)))
+	bw.write(header)
+
+	var rows int64
+	if stats.RowCount > 0 {
+		it := s.Scan(ctx, table, time.Unix(0, math.MinInt64), time.Unix(0, stats.NewestTS))
+		defer it.Close()
+		for it.Next() {
+			if err := bw.add(it.Row()); err != nil {
+				return err
+			}
+			rows++
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	bw.flushBlock()
+
+	bw.uvarint(0)
+	var count [8]byte
+	binary.BigEndian.PutUint64(count[:], uint64(rows))
+	bw.write(count[:])
+	bw.write(bw.sum.Sum(nil))
+
+	if bw.err != nil {
+		return bw.err
+	}
+	if err := bw.w.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// backupWriter frames rows into blocks and keeps the running checksum of the archive
+type backupWriter struct {
+	w   *bufio.Writer
+	sum hash.Hash
+	err error
+
+	rows  int
+	block bytes.Buffer
+}
+
+func (bw *backupWriter) write(b []byte) {
+	if bw.err != nil {
+		return
+	}
+	bw.sum.Write(b)
+	_, bw.err = bw.w.Write(b)
+}
+
+func (bw *backupWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	bw.write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (bw *backupWriter) add(row *proto.DBTuple) error {
+	dat, err := protobuf.Marshal(row)
+	if err != nil {
+		return err
+	}
+	var b [binary.MaxVarintLen64]byte
+	bw.block.Write(b[:binary.PutUvarint(b[:], uint64(len(dat)))])
+	bw.block.Write(dat)
+	bw.rows++
+
+	if bw.rows >= backupBlockRows || bw.block.Len() >= backupBlockBytes {
+		bw.flushBlock()
+	}
+	return bw.err
+}
+
+// flushBlock writes the rows added since the last block
+func (bw *backupWriter) flushBlock() {
+	if bw.rows == 0 {
+		return
+	}
+	bw.uvarint(uint64(bw.rows))
+	bw.uvarint(uint64(bw.block.Len()))
+	bw.write(bw.block.Bytes())
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum(bw.block.Bytes(), backupCRC))
+	bw.write(crc[:])
+
+	bw.rows = 0
+	bw.block.Reset()
+}
+
+// ReadBackupHeader reads the header at the start of a backup archive
+func ReadBackupHeader(r io.Reader) (*BackupHeader, error) {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := br.header()
+	return header, err
+}
+
+// Restore will append the rows of a backup archive to table, or to the table the
+// backup was taken from when table is empty
+// Every block of rows is checked before it is written, but a damaged archive can
+// still fail after some blocks were restored. Each block carries an idempotency key,
+// so running the same restore again soon after a failure does not duplicate them
+func (s *AppendDbSDKClient) Restore(ctx context.Context, table string, r io.Reader) error {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return err
+	}
+	header, raw, err := br.header()
+	if err != nil {
+		return err
+	}
+	if table == "" {
+		table = header.Table
+	}
+
+	var total int64
+	for block := 0; ; block++ {
+		n := br.uvarint()
+		if n == 0 || br.err != nil {
+			break
+		}
+		size := br.uvarint()
+		if size > 64<<20 {
+			return fmt.Errorf("%w: block %d is too large", ErrBadBackup, block)
+		}
+		dat := br.read(int(size))
+		crc := br.read(4)
+		if br.err != nil {
+			break
+		}
+		if binary.BigEndian.Uint32(crc) != crc32.Checksum(dat, backupCRC) {
+			return fmt.Errorf("%w: block %d is corrupt", ErrBadBackup, block)
+		}
+
+		rows, err := decodeBackupBlock(dat, n)
+		if err != nil {
+			return fmt.Errorf("%w: block %d: %v", ErrBadBackup, block, err)
+		}
+		key := restoreKey(table, raw, block, crc)
+		if err := s.AppendBatch(ContextWithIdempotencyKey(ctx, key), table, rows); err != nil {
+			return err
+		}
+		total += int64(n)
+	}
+
+	count := br.read(8)
+	want := br.sum.Sum(nil)
+	got := br.read(sha256.Size)
+	if br.err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBackup, br.err)
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadBackup)
+	}
+	if int64(binary.BigEndian.Uint64(count)) != total {
+		return fmt.Errorf("%w: expected %d rows, read %d", ErrBadBackup, binary.BigEndian.Uint64(count), total)
+	}
+
+	// reading to the end makes gzip check its own checksum
+	if n, err := io.Copy(io.Discard, br.r); err != nil || n > 0 {
+		return fmt.Errorf("%w: unexpected data after the trailer", ErrBadBackup)
+	}
+	return nil
+}
+
+func decodeBackupBlock(dat []byte, n uint64) ([]Row, error) {
+	if n > uint64(len(dat)) {
+		return nil, errors.New("row count larger than the block")
+	}
+	rows := make([]Row, 0, n)
+	for len(dat) > 0 {
+		size, k := binary.Uvarint(dat)
+		if k <= 0 || size > uint64(len(dat)-k) {
+			return nil, errors.New("truncated row")
+		}
+		var row proto.DBTuple
+		if err := protobuf.Unmarshal(dat[k:k+int(size)], &row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, Row{Ts: time.Unix(0, row.Ts), Data: row.Data})
+		dat = dat[k+int(size):]
+	}
+	if uint64(len(rows)) != n {
+		return nil, fmt.Errorf("expected %d rows, found %d", n, len(rows))
+	}
+	return rows, nil
+}
+
+// restoreKey identifies a block of a backup, the header carries the creation time so
+// blocks of different backups of the same table don't collide
+func restoreKey(table string, header []byte, block int, crc []byte) string {
+	h := sha256.New()
+	h.Write([]byte(table))
+	h.Write([]byte{0})
+	h.Write(header)
+	binary.Write(h, binary.BigEndian, int64(block))
+	h.Write(crc)
+	return "restore-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// backupReader reads an archive while keeping the running checksum, the first error sticks
+type backupReader struct {
+	r   *bufio.Reader
+	sum hash.Hash
+	err error
+}
+
+func newBackupReader(r io.Reader) (*backupReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBackup, err)
+	}
+	return &backupReader{r: bufio.NewReaderSize(zr, 1<<16), sum: sha256.New()}, nil
+}
+
+func (br *backupReader) read(n int) []byte {
+	if br.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		br.err = err
+		return nil
+	}
+	br.sum.Write(b)
+	return b
+}
+
+func (br *backupReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	var buf [binary.MaxVarintLen64]byte
+	for i := range buf {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			br.err = err
+			return 0
+		}
+		buf[i] = b
+		if b < 0x80 {
+			br.sum.Write(buf[:i+1])
+			v, _ := binary.Uvarint(buf[:i+1])
+			return v
+		}
+	}
+	br.err = errors.New("varint overflows")
+	return 0
+}
+
+// header reads the magic and the header, it returns the raw header too
+func (br *backupReader) header() (*BackupHeader, []byte, error) {
+	magic := br.read(len(backupMagic) + 1)
+	if br.err != nil || !bytes.Equal(magic[:len(backupMagic)], backupMagic) {
+		return nil, nil, ErrBadBackup
+	}
+	if magic[len(backupMagic)] != backupVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrBadBackup, magic[len(backupMagic)])
+	}
+
+	size := br.uvarint()
+	if size > 1<<20 {
+		return nil, nil, fmt.Errorf("%w: header is too large", ErrBadBackup)
+	}
+	raw := br.read(int(size))
+	if br.err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBadBackup, br.err)
+	}
+
+	var header BackupHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrBadBackup, err)
+	}
+	return &header, raw, nil
+}
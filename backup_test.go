@@ -0,0 +1,172 @@
+package dbsdk_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// backupTable fills a table with rows spanning several blocks and returns its backup
+func backupTable(t *testing.T, client *dbsdk.AppendDbSDKClient, table string, n int) []byte {
+	t.Helper()
+	var rows []dbsdk.Row
+	for i := 0; i < n; i++ {
+		rows = append(rows, dbsdk.Row{Ts: time.Unix(0, int64(i/3)), Data: []byte(fmt.Sprintf("row %d", i))})
+	}
+	for i := 0; i < len(rows); i += 500 {
+		end := i + 500
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := client.AppendBatch(context.Background(), table, rows[i:end]); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := client.Backup(context.Background(), table, &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sameRows(t *testing.T, got, want []*proto.DBTuple) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Ts != want[i].Ts || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Fatalf("row %d is %d=%q, want %d=%q", i, got[i].Ts, got[i].Data, want[i].Ts, want[i].Data)
+		}
+	}
+}
+
+// rewrite decompresses an archive, changes it with fn and compresses it again
+func rewrite(t *testing.T, archive []byte, fn func([]byte) []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(fn(raw))
+	zw.Close()
+	return buf.Bytes()
+}
+
+// firstBlock returns the offset of the rows of the first block of a decompressed archive
+func firstBlock(raw []byte) int {
+	off := 5
+	size, n := binary.Uvarint(raw[off:])
+	off += n + int(size)
+	_, n = binary.Uvarint(raw[off:])
+	off += n
+	_, n = binary.Uvarint(raw[off:])
+	return off + n
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 999, 1000, 2500} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			archive := backupTable(t, client, "t", n)
+
+			header, err := dbsdk.ReadBackupHeader(bytes.NewReader(archive))
+			if err != nil {
+				t.Fatalf("header: %v", err)
+			}
+			if header.Table != "t" || header.RowCount != int64(n) || header.OldestTS != 0 || header.NewestTS != int64((n-1)/3) {
+				t.Fatalf("header %+v", header)
+			}
+
+			if err := client.Restore(context.Background(), "copy", bytes.NewReader(archive)); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			sameRows(t, srv.Rows("copy"), srv.Rows("t"))
+
+			// without a table the rows go back to the table the backup was taken from
+			other, otherClient := dbsdktest.Start(t)
+			if err := otherClient.Restore(context.Background(), "", bytes.NewReader(archive)); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			sameRows(t, other.Rows("t"), srv.Rows("t"))
+		})
+	}
+}
+
+func TestRestoreDamaged(t *testing.T) {
+	_, client := dbsdktest.Start(t)
+	archive := backupTable(t, client, "t", 2500)
+
+	tests := []struct {
+		name    string
+		archive []byte
+		// rows restored before the damage was found, -1 when it depends on the compression
+		rows int
+	}{
+		{name: "not a backup", archive: []byte("sensors.csv")},
+		{name: "bad magic", archive: rewrite(t, archive, func(raw []byte) []byte {
+			raw[0] = 'X'
+			return raw
+		})},
+		{name: "corrupt first block", archive: rewrite(t, archive, func(raw []byte) []byte {
+			raw[firstBlock(raw)+10] ^= 0xff
+			return raw
+		})},
+		{name: "truncated trailer", archive: rewrite(t, archive, func(raw []byte) []byte {
+			return raw[:len(raw)-10]
+		}), rows: 2500},
+		{name: "bad checksum", archive: rewrite(t, archive, func(raw []byte) []byte {
+			raw[len(raw)-1] ^= 0xff
+			return raw
+		}), rows: 2500},
+		{name: "extra data", archive: rewrite(t, archive, func(raw []byte) []byte {
+			return append(raw, 0)
+		}), rows: 2500},
+		{name: "truncated gzip", archive: archive[:len(archive)*2/3], rows: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			err := client.Restore(context.Background(), "t", bytes.NewReader(tt.archive))
+			if !errors.Is(err, dbsdk.ErrBadBackup) {
+				t.Fatalf("got %v, want ErrBadBackup", err)
+			}
+			if tt.rows >= 0 {
+				if n := len(srv.Rows("t")); n != tt.rows {
+					t.Fatalf("%d rows were restored, want %d", n, tt.rows)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreAgain(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	archive := backupTable(t, client, "t", 2500)
+
+	// every block carries the same key both times, so the second restore adds nothing
+	for i := 0; i < 2; i++ {
+		if err := client.Restore(context.Background(), "copy", bytes.NewReader(archive)); err != nil {
+			t.Fatalf("restore %d: %v", i+1, err)
+		}
+	}
+	sameRows(t, srv.Rows("copy"), srv.Rows("t"))
+}
@@ -0,0 +1,62 @@
+package dbsdk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// Row is a single row of a table
+type Row struct {
+	Ts   time.Time
+	Data []byte
+}
+
+// RowError describes why a single row of a batch was rejected
+type RowError struct {
+	Index   int
+	Message string
+}
+
+// BatchError is returned by AppendBatch when the server rejected the batch
+// None of the rows of a rejected batch were written
+type BatchError struct {
+	Rows []RowError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Rows) == 0 {
+		return "batch rejected"
+	}
+	return fmt.Sprintf("batch rejected: %d rows failed, row %d: %s", len(e.Rows), e.Rows[0].Index, e.Rows[0].Message)
+}
+
+// AppendBatch writes all the rows to the table in a single request
+// Either every row is written or none are, in which case a *BatchError reports the failing rows
+// Like AppendContext the batch carries an idempotency key
+func (s *AppendDbSDKClient) AppendBatch(ctx context.Context, table string, rows []Row) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	req := proto.BatchAppendRequest{Table: table, Data: make([]*proto.DBTuple, len(rows)), IdempotencyKey: idempotencyKey(ctx)}
+	for i, row := range rows {
+		req.Data[i] = &proto.DBTuple{Ts: row.Ts.UnixNano(), Data: row.Data}
+	}
+
+	resp, err := s.stub.BatchAppend(ctx, &req)
+	if err != nil {
+		return wrapErr("BatchAppend", table, err)
+	}
+
+	if len(resp.Errors) > 0 {
+		batchErr := &BatchError{Rows: make([]RowError, len(resp.Errors))}
+		for i, rowErr := range resp.Errors {
+			batchErr.Rows[i] = RowError{Index: int(rowErr.Index), Message: rowErr.Message}
+		}
+		return batchErr
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/server"
+)
+
+// startWith starts a server built with opts and a client connected to it
+func startWith(t *testing.T, opts ...server.Option) (*dbsdktest.Server, *dbsdk.AppendDbSDKClient) {
+	t.Helper()
+	srv := dbsdktest.NewServer(opts...)
+	t.Cleanup(srv.Close)
+	client, err := srv.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
+
+func TestBatchRowErrors(t *testing.T) {
+	srv, client := startWith(t, server.WithMaxRowSize(4))
+
+	var rows []dbsdk.Row
+	for i, data := range []string{"ok", "too long", "fits", "", "longer still"} {
+		rows = append(rows, dbsdk.Row{Ts: time.Unix(0, int64(i+1)), Data: []byte(data)})
+	}
+	err := client.AppendBatch(context.Background(), "t", rows)
+
+	var batchErr *dbsdk.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a *BatchError", err)
+	}
+	want := []dbsdk.RowError{
+		{Index: 1, Message: "row data is 8 bytes, the limit is 4"},
+		{Index: 4, Message: "row data is 12 bytes, the limit is 4"},
+	}
+	if !reflect.DeepEqual(batchErr.Rows, want) {
+		t.Fatalf("got %+v, want %+v", batchErr.Rows, want)
+	}
+	if msg := "batch rejected: 2 rows failed, row 1: row data is 8 bytes, the limit is 4"; err.Error() != msg {
+		t.Fatalf("got message %q, want %q", err.Error(), msg)
+	}
+	if n := len(srv.Rows("t")); n != 0 {
+		t.Fatalf("got %d rows stored, want the batch rejected whole", n)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+)
+
+func runBackup(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", "archive to write, stdout when empty")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := client.Backup(ctx, table, w); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	return backupFile(ctx, client, table, *out)
+}
+
+// backupFile writes the backup to path, removing what was written if it fails
+func backupFile(ctx context.Context, client *dbsdk.AppendDbSDKClient, table, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = client.Backup(ctx, table, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func runRestore(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	file := fs.String("file", "", "archive to restore, - for stdin")
+	table := fs.String("table", "", "table to restore into (default the table the backup was taken from)")
+	if _, err := parseArgs(fs, args, false); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("restore: missing -file")
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if *file == "-" {
+		return client.Restore(ctx, *table, bufio.NewReader(os.Stdin))
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := dbsdk.ReadBackupHeader(f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	into := *table
+	if into == "" {
+		into = header.Table
+	}
+	fmt.Fprintf(os.Stderr, "restoring %d rows of %s taken %s into %s\n", header.RowCount, header.Table, header.Created.Format("2006-01-02 15:04:05 MST"), into)
+	return client.Restore(ctx, into, bufio.NewReader(f))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// parseArgs parses the flags of a subcommand, accepting the table before or after them
+func parseArgs(fs *flag.FlagSet, args []string, tableArg bool) (string, error) {
+	table := ""
+	if tableArg && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		table, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	if !tableArg {
+		if fs.NArg() > 0 {
+			return "", fmt.Errorf("%s: unexpected argument %q", fs.Name(), fs.Arg(0))
+		}
+		return "", nil
+	}
+	if table == "" && fs.NArg() > 0 {
+		table = fs.Arg(0)
+	} else if fs.NArg() > 0 {
+		return "", fmt.Errorf("%s: unexpected argument %q", fs.Name(), fs.Arg(0))
+	}
+	if table == "" {
+		return "", fmt.Errorf("%s: missing table name", fs.Name())
+	}
+	return table, nil
+}
+
+// rowPrinter writes rows as text or JSON lines
+type rowPrinter struct {
+	w        *bufio.Writer
+	json     bool
+	encoding string
+}
+
+func addPrintFlags(fs *flag.FlagSet) (*string, *string) {
+	format := fs.String("format", "text", "output format: text or json")
+	encoding := fs.String("encoding", "utf8", "how to print row data in text format: utf8, base64 or hex")
+	return format, encoding
+}
+
+func newRowPrinter(format, encoding string) (*rowPrinter, error) {
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if encoding != "utf8" && encoding != "base64" && encoding != "hex" {
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	return &rowPrinter{w: bufio.NewWriter(os.Stdout), json: format == "json", encoding: encoding}, nil
+}
+
+func (p *rowPrinter) print(row *proto.DBTuple) error {
+	ts := time.Unix(0, row.Ts).UTC().Format(time.RFC3339Nano)
+	if p.json {
+		line, err := json.Marshal(struct {
+			Ts   string `json:"ts"`
+			Data []byte `json:"data"`
+		}{ts, row.Data})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", line)
+		return err
+	}
+
+	var dat string
+	switch p.encoding {
+	case "base64":
+		dat = base64.StdEncoding.EncodeToString(row.Data)
+	case "hex":
+		dat = hex.EncodeToString(row.Data)
+	default:
+		dat = string(row.Data)
+	}
+	_, err := fmt.Fprintf(p.w, "%s\t%s\n", ts, dat)
+	return err
+}
+
+func (p *rowPrinter) flush() error {
+	return p.w.Flush()
+}
+
+func runAppend(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("append", flag.ContinueOnError)
+	var ts timeFlag
+	fs.Var(&ts, "ts", "timestamp of the row (default now)")
+	data := fs.String("data", "", "row data")
+	file := fs.String("file", "", "read the row data from a file, - for stdin")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	var dat []byte
+	switch {
+	case *data != "" && *file != "":
+		return errors.New("append: use either -data or -file")
+	case *data != "":
+		dat = []byte(*data)
+	case *file != "" && *file != "-":
+		if dat, err = os.ReadFile(*file); err != nil {
+			return err
+		}
+	default:
+		if dat, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	return client.AppendContext(ctx, table, ts.or(time.Now()), dat)
+}
+
+func runQuery(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	var start, stop timeFlag
+	fs.Var(&start, "start", "start of the range, inclusive (default the oldest row)")
+	fs.Var(&stop, "stop", "end of the range, inclusive (default the newest row)")
+	limit := fs.Int("limit", 0, "print at most this many rows")
+	first := fs.Int("first", 0, "print the oldest n rows of the table")
+	last := fs.Int("last", 0, "print the newest n rows of the table, newest first")
+	format, encoding := addPrintFlags(fs)
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	p, err := newRowPrinter(*format, *encoding)
+	if err != nil {
+		return err
+	}
+	defer p.flush()
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if *first > 0 || *last > 0 {
+		var rows []*proto.DBTuple
+		if *first > 0 {
+			rows, err = client.Earliest(ctx, table, *first)
+		} else {
+			rows, err = client.Latest(ctx, table, *last)
+		}
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := p.print(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	it := client.Scan(ctx, table, start.or(time.Unix(0, math.MinInt64)), stop.or(time.Unix(0, math.MaxInt64)))
+	defer it.Close()
+	for n := 0; (*limit <= 0 || n < *limit) && it.Next(); n++ {
+		if err := p.print(it.Row()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func runStats(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	stats, err := client.StatsContext(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("table:  %s\nrows:   %d\n", table, stats.RowCount)
+	if stats.RowCount > 0 {
+		fmt.Printf("oldest: %s\nnewest: %s\n",
+			time.Unix(0, stats.OldestTS).UTC().Format(time.RFC3339Nano),
+			time.Unix(0, stats.NewestTS).UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+func runTables(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("tables", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only list the tables whose name starts with prefix")
+	if _, err := parseArgs(fs, args, false); err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	token := ""
+	for {
+		tables, next, err := client.ListTablesPage(ctx, *prefix, 1000, token)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			fmt.Fprintln(w, table)
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+func runPurge(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	backup := fs.String("backup", "", "back the table up to this file first")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		fmt.Printf("purge removes %s and all of its rows for good, type the table name to confirm: ", table)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != table {
+			return errors.New("purge: not confirmed")
+		}
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if *backup != "" {
+		if err := backupFile(ctx, client, table, *backup); err != nil {
+			return fmt.Errorf("purge: backup: %w", err)
+		}
+	}
+
+	return client.PurgeContext(ctx, table)
+}
+
+func runTail(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	var from timeFlag
+	fs.Var(&from, "from", "replay the rows from this time before following (default now)")
+	format, encoding := addPrintFlags(fs)
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	p, err := newRowPrinter(*format, *encoding)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tail := client.Tail(ctx, table, from.or(time.Now()))
+	defer tail.Close()
+	for tail.Next() {
+		if err := p.print(tail.Row()); err != nil {
+			return err
+		}
+		// rows arrive one by one, show them as they come
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+
+	// stopping with ctrl-c is the normal way out
+	if err := tail.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
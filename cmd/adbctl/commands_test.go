@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"testing"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		tableArg bool
+		table    string
+		limit    int
+		err      bool
+	}{
+		{args: []string{"t"}, tableArg: true, table: "t"},
+		{args: []string{"t", "-limit", "5"}, tableArg: true, table: "t", limit: 5},
+		{args: []string{"-limit", "5", "t"}, tableArg: true, table: "t", limit: 5},
+		{args: []string{"-limit", "5"}, tableArg: true, err: true},
+		{args: []string{}, tableArg: true, err: true},
+		{args: []string{"t", "u"}, tableArg: true, err: true},
+		{args: []string{"t", "-limit", "5", "u"}, tableArg: true, err: true},
+		{args: []string{"-limit", "x", "t"}, tableArg: true, err: true},
+		{args: []string{"-limit", "5"}, limit: 5},
+		{args: []string{"t"}, err: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		limit := fs.Int("limit", 0, "")
+		table, err := parseArgs(fs, tt.args, tt.tableArg)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseArgs(%q): got table %q, want an error", tt.args, table)
+			}
+			continue
+		}
+		if err != nil || table != tt.table || *limit != tt.limit {
+			t.Errorf("parseArgs(%q) = %q, limit %d, %v", tt.args, table, *limit, err)
+		}
+	}
+}
+
+func TestCommandsParseBeforeDialing(t *testing.T) {
+	dial := func() (*dbsdk.AppendDbSDKClient, error) {
+		t.Fatal("dialed the server")
+		return nil, nil
+	}
+	for name, cmd := range commands {
+		t.Run(name, func(t *testing.T) {
+			err := cmd.run(context.Background(), dial, []string{"-h"})
+			if !errors.Is(err, flag.ErrHelp) {
+				t.Fatalf("got %v, want flag.ErrHelp", err)
+			}
+			if err := cmd.run(context.Background(), dial, []string{"-no-such-flag"}); err == nil {
+				t.Fatal("an unknown flag was accepted")
+			}
+		})
+	}
+}
+
+func TestCommandsRejectBeforeDialing(t *testing.T) {
+	dial := func() (*dbsdk.AppendDbSDKClient, error) {
+		t.Fatal("dialed the server")
+		return nil, nil
+	}
+	tests := map[string][]string{
+		// without -o the files of -split have nowhere to go
+		"export": {"-split", "1h", "t"},
+		// -dst-insecure alone would migrate to the default address
+		"migrate": {"-dst-insecure", "t"},
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := commands[name].run(context.Background(), dial, args); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
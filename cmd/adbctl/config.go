@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+)
+
+// config describes how to reach a server, it can be loaded from a JSON file
+// and every field can be overridden with a flag
+type config struct {
+	Host       string        `json:"host"`
+	Port       int           `json:"port"`
+	Cert       string        `json:"cert"`
+	ClientCert string        `json:"clientCert"`
+	ClientKey  string        `json:"clientKey"`
+	ServerName string        `json:"serverName"`
+	Insecure   bool          `json:"insecure"`
+	Timeout    time.Duration `json:"timeout"`
+}
+
+// defaultConfigPath is $ADBCTL_CONFIG or ~/.adbctl.json
+func defaultConfigPath() string {
+	if path := os.Getenv("ADBCTL_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".adbctl.json")
+}
+
+func loadConfig(path string, required bool) (config, error) {
+	cfg := config{Host: "localhost", Port: 7777, Timeout: 10 * time.Second}
+	if path == "" {
+		return cfg, nil
+	}
+
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	// timeout is written as a duration string like "30s"
+	var raw struct {
+		config
+		Timeout string `json:"timeout"`
+	}
+	raw.config = cfg
+	if err := json.Unmarshal(dat, &raw); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg = raw.config
+	if raw.Timeout != "" {
+		if cfg.Timeout, err = time.ParseDuration(raw.Timeout); err != nil {
+			return cfg, fmt.Errorf("%s: timeout: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// connFlags are the flags that override a config file, the global ones for the server
+// to talk to and the dst- ones of migrate for the server to copy to
+type connFlags struct {
+	prefix     string
+	config     *string
+	host       *string
+	port       *int
+	cert       *string
+	clientCert *string
+	clientKey  *string
+	serverName *string
+	insecure   *bool
+	timeout    *time.Duration
+}
+
+func addConnFlags(fs *flag.FlagSet, prefix, defaultConfig string) *connFlags {
+	return &connFlags{
+		prefix:     prefix,
+		config:     fs.String(prefix+"config", defaultConfig, "path to a JSON config file"),
+		host:       fs.String(prefix+"host", "", "server hostname"),
+		port:       fs.Int(prefix+"port", 0, "server port"),
+		cert:       fs.String(prefix+"cert", "", "path to the server's certificate or CA bundle"),
+		clientCert: fs.String(prefix+"client-cert", "", "path to a client certificate for mutual tls"),
+		clientKey:  fs.String(prefix+"client-key", "", "path to the client certificate's key"),
+		serverName: fs.String(prefix+"server-name", "", "name to verify the server's certificate against"),
+		insecure:   fs.Bool(prefix+"insecure", false, "connect without tls"),
+		timeout:    fs.Duration(prefix+"timeout", 0, "timeout of each request"),
+	}
+}
+
+// named reports whether the server was given by the prefixed -host or -config flag,
+// the other flags alone would connect to the default address
+func (f *connFlags) named(fs *flag.FlagSet) bool {
+	named := false
+	fs.Visit(func(fl *flag.Flag) {
+		named = named || fl.Name == f.prefix+"host" || fl.Name == f.prefix+"config"
+	})
+	return named
+}
+
+// resolve merges the flags that were set over the config file
+func (f *connFlags) resolve(fs *flag.FlagSet) (config, error) {
+	explicit := false
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == f.prefix+"config" {
+			explicit = true
+		}
+	})
+
+	cfg, err := loadConfig(*f.config, explicit)
+	if err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		if !strings.HasPrefix(fl.Name, f.prefix) {
+			return
+		}
+		switch strings.TrimPrefix(fl.Name, f.prefix) {
+		case "host":
+			cfg.Host = *f.host
+		case "port":
+			cfg.Port = *f.port
+		case "cert":
+			cfg.Cert = *f.cert
+		case "client-cert":
+			cfg.ClientCert = *f.clientCert
+		case "client-key":
+			cfg.ClientKey = *f.clientKey
+		case "server-name":
+			cfg.ServerName = *f.serverName
+		case "insecure":
+			cfg.Insecure = *f.insecure
+		case "timeout":
+			cfg.Timeout = *f.timeout
+		}
+	})
+	return cfg, nil
+}
+
+// connect opens a client with the config
+func (cfg config) connect(ctx context.Context) (*dbsdk.AppendDbSDKClient, error) {
+	opts := []dbsdk.Option{dbsdk.WithRequestTimeout(cfg.Timeout)}
+	if cfg.Insecure {
+		opts = append(opts, dbsdk.WithInsecure())
+	} else {
+		if cfg.Cert != "" {
+			opts = append(opts, dbsdk.WithCABundle(cfg.Cert))
+		} else {
+			opts = append(opts, dbsdk.WithSystemCertPool())
+		}
+		if cfg.ClientCert != "" {
+			opts = append(opts, dbsdk.WithClientCertificate(cfg.ClientCert, cfg.ClientKey))
+		}
+		if cfg.ServerName != "" {
+			opts = append(opts, dbsdk.WithServerName(cfg.ServerName))
+		}
+	}
+
+	return dbsdk.NewClient(ctx, fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), opts...)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/export"
+)
+
+func runExport(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var start, stop timeFlag
+	fs.Var(&start, "start", "start of the range, inclusive (default the oldest row)")
+	fs.Var(&stop, "stop", "end of the range, inclusive (default the newest row)")
+	out := fs.String("o", "", "file to write, stdout when empty")
+	format := fs.String("format", "", "jsonl, csv or parquet (default from the -o extension, else jsonl)")
+	encoding := fs.String("encoding", "base64", "how to write row data in jsonl and csv: base64, hex or utf8")
+	split := fs.String("split", "", "write one file per window of this duration, like 1h or 1d")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	opts := []export.Option{export.WithEncoding(export.Encoding(*encoding))}
+	if *format != "" {
+		opts = append(opts, export.WithFormat(export.Format(*format)))
+	}
+	if *split != "" {
+		d, err := parseDuration(*split)
+		if err != nil {
+			return fmt.Errorf("export: -split: %w", err)
+		}
+		opts = append(opts, export.WithSplit(d))
+	}
+	if *split != "" && *out == "" {
+		return errors.New("export: -split needs -o")
+	}
+	from, to := start.or(time.Unix(0, math.MinInt64)), stop.or(time.Unix(0, math.MaxInt64))
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if _, err := export.Table(ctx, client, table, from, to, w, opts...); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	paths, err := export.Files(ctx, client, table, from, to, *out, opts...)
+	for _, path := range paths {
+		fmt.Fprintln(os.Stderr, path)
+	}
+	return err
+}
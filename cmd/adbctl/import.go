@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/export"
+	"github.com/r-coffee/db-append-only-sdk/importer"
+)
+
+func runImport(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "JSONL or CSV file to import")
+	format := fs.String("format", "", "jsonl or csv (default from the -file extension)")
+	encoding := fs.String("encoding", "base64", "how row data is encoded in the file: base64, hex or utf8")
+	batch := fs.Int("batch", 1000, "rows per append")
+	skip := fs.Bool("skip-invalid", false, "skip the lines that can't be parsed instead of stopping")
+	restart := fs.Bool("restart", false, "ignore the checkpoint of an earlier run and start from the beginning")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("import: missing -file")
+	}
+
+	if *restart {
+		if err := os.Remove(*file + ".checkpoint"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	opts := []importer.Option{
+		importer.WithEncoding(export.Encoding(*encoding)),
+		importer.WithBatchSize(*batch),
+		importer.WithProgress(5*time.Second, func(p importer.Progress) {
+			fmt.Fprintf(os.Stderr, "%d rows, %.1f%%, %.0f rows/s\n", p.Rows, 100*float64(p.Offset)/float64(max64(p.Size, 1)), p.RowsPerSec)
+		}),
+	}
+	if *format != "" {
+		opts = append(opts, importer.WithFormat(export.Format(*format)))
+	}
+	if *skip {
+		opts = append(opts, importer.WithSkipInvalid(func(err *importer.ParseError) {
+			fmt.Fprintf(os.Stderr, "skipped %v\n", err)
+		}))
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	p, err := importer.File(ctx, client, table, *file, opts...)
+	if errors.Is(err, importer.ErrFileChanged) {
+		return fmt.Errorf("%w, use -restart to import it from the start", err)
+	}
+	if err != nil {
+		if p.Rows > 0 {
+			return fmt.Errorf("%w\n%d rows imported so far, run again to resume", err, p.Rows)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d rows, skipped %d, in %s\n", p.Rows, p.Skipped, p.Elapsed.Round(time.Millisecond))
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
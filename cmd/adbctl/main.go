@@ -0,0 +1,106 @@
+// Command adbctl inspects and manages the tables of an append-only database server
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+)
+
+// command is a subcommand of adbctl
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, dial dialFunc, args []string) error
+}
+
+// dialFunc connects to the server, commands call it once their flags are parsed
+// so that bad flags and -h don't need a reachable server
+type dialFunc func() (*dbsdk.AppendDbSDKClient, error)
+
+var commands = map[string]command{
+	"append":  {"append [flags] <table>", "append a row, its data read from -data, -file or stdin", runAppend},
+	"backup":  {"backup [flags] <table>", "write a table to a compressed, checksummed archive", runBackup},
+	"export":  {"export [flags] <table>", "write the rows of a table to JSONL, CSV or Parquet files", runExport},
+	"import":  {"import [flags] <table>", "load a JSONL or CSV file into a table, resuming an interrupted import", runImport},
+	"restore": {"restore [flags]", "append the rows of a backup archive to a table", runRestore},
+	"query":   {"query [flags] <table>", "print the rows of a table in a time range", runQuery},
+	"stats":   {"stats <table>", "print the row count and time span of a table", runStats},
+	"tables":  {"tables [flags]", "list the tables", runTables},
+	"migrate": {"migrate [flags] <table>", "copy a table to another server and verify the copy", runMigrate},
+	"purge":   {"purge [flags] <table>", "delete a table and all of its rows", runPurge},
+	"tail":    {"tail [flags] <table>", "print the rows of a table as they are appended", runTail},
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "usage: adbctl [global flags] <command> [flags] [args]\n\ncommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %-30s %s\n", commands[name].usage, commands[name].help)
+		}
+		fmt.Fprintf(out, "\ntimes are RFC3339, now, relative like -1h or -1d12h, or unix nanoseconds\n\nglobal flags:\n")
+		fs.PrintDefaults()
+	}
+}
+
+func main() {
+	fs := flag.NewFlagSet("adbctl", flag.ExitOnError)
+	conn := addConnFlags(fs, "", defaultConfigPath())
+	fs.Usage = usage(fs)
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "adbctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var client *dbsdk.AppendDbSDKClient
+	dial := func() (*dbsdk.AppendDbSDKClient, error) {
+		if client != nil {
+			return client, nil
+		}
+		cfg, err := conn.resolve(fs)
+		if err != nil {
+			return nil, err
+		}
+		client, err = cfg.connect(ctx)
+		return client, err
+	}
+
+	err := cmd.run(ctx, dial, fs.Args()[1:])
+	if client != nil {
+		client.Close()
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "adbctl: %v\n", err)
+	os.Exit(1)
+}
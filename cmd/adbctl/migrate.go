@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+)
+
+func runMigrate(ctx context.Context, dial dialFunc, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dstConn := addConnFlags(fs, "dst-", "")
+	var start, stop timeFlag
+	fs.Var(&start, "start", "start of the range, inclusive (default the oldest row)")
+	fs.Var(&stop, "stop", "end of the range, inclusive (default the newest row)")
+	rename := fs.String("rename", "", "name of the table on the destination (default the same name)")
+	workers := fs.Int("workers", 4, "chunks copied at the same time")
+	chunk := fs.String("chunk", "", "span of time of a chunk, like 1h or 1d (default 8 chunks per worker)")
+	rate := fs.Float64("rate", 0, "most rows written per second, 0 for no limit")
+	runID := fs.String("run-id", "", "run id of a failed migration to run again without duplicating rows, with its -start, -stop and -chunk")
+	noVerify := fs.Bool("no-verify", false, "skip comparing the tables once copied")
+	table, err := parseArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+	if !dstConn.named(fs) {
+		return errors.New("migrate: the destination needs -dst-config or -dst-host")
+	}
+
+	opts := []dbsdk.MigrateOption{
+		dbsdk.WithMigrateRange(start.or(time.Unix(0, math.MinInt64)), stop.or(time.Unix(0, math.MaxInt64))),
+		dbsdk.WithRename(*rename),
+		dbsdk.WithRunID(*runID),
+		dbsdk.WithWorkers(*workers),
+		dbsdk.WithRateLimit(*rate),
+		dbsdk.WithVerify(!*noVerify),
+		dbsdk.WithMigrateProgress(func(p dbsdk.MigrateProgress) {
+			fmt.Fprintf(os.Stderr, "chunk %d/%d, %d rows\n", p.ChunksDone, p.Chunks, p.Rows)
+		}),
+	}
+	if *chunk != "" {
+		d, err := parseDuration(*chunk)
+		if err != nil {
+			return fmt.Errorf("migrate: -chunk: %w", err)
+		}
+		opts = append(opts, dbsdk.WithChunkDuration(d))
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := dstConn.resolve(fs)
+	if err != nil {
+		return err
+	}
+	dst, err := cfg.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate: destination: %w", err)
+	}
+	defer dst.Close()
+
+	began := time.Now()
+	res, err := dbsdk.Migrate(ctx, client, dst, table, opts...)
+	if err != nil {
+		var verr *dbsdk.VerifyError
+		if res != nil && res.ChunkDuration > 0 && !errors.As(err, &verr) {
+			// the same range and chunks give the batches the same keys
+			fmt.Fprintf(os.Stderr, "run it again with -run-id %s -start %d -stop %d -chunk %s to skip the batches already copied\n",
+				res.RunID, res.Start.UnixNano(), res.Stop.UnixNano(), res.ChunkDuration)
+		}
+		return err
+	}
+	verified := ""
+	if res.Verified {
+		verified = ", verified"
+	}
+	fmt.Fprintf(os.Stderr, "copied %d rows in %d chunks in %s%s\n", res.Rows, res.Chunks, time.Since(began).Round(time.Millisecond), verified)
+	return nil
+}
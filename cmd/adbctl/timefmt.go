@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTime understands
+//
+//	now
+//	RFC3339 timestamps            2021-06-01T12:00:00Z
+//	durations relative to now     -1h, -30m, +15m, -7d, -1d12h
+//	unix nanoseconds              1622548800000000000, -86400000000000
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ns), nil
+	}
+
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		d, err := parseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad relative time %q: %w", s, err)
+		}
+		if s[0] == '-' {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, use now, RFC3339, a relative duration like -1h or unix nanoseconds", s)
+	}
+	return t, nil
+}
+
+// parseDuration is time.ParseDuration with a leading count of days, like 7d or 1d12h
+func parseDuration(s string) (time.Duration, error) {
+	i := strings.IndexByte(s, 'd')
+	if i < 0 {
+		return time.ParseDuration(s)
+	}
+
+	days := s[:i]
+	if days == "" || (days[0] != '.' && (days[0] < '0' || days[0] > '9')) {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	n, err := strconv.ParseFloat(days, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	d := time.Duration(n * float64(24*time.Hour))
+
+	if rest := s[i+1:]; rest != "" {
+		if rest[0] == '-' || rest[0] == '+' {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d += r
+	}
+	return d, nil
+}
+
+// timeFlag is a flag.Value holding a time parsed with parseTime
+type timeFlag struct {
+	t   time.Time
+	set bool
+}
+
+func (f *timeFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	return f.t.Format(time.RFC3339Nano)
+}
+
+func (f *timeFlag) Set(s string) error {
+	t, err := parseTime(s, time.Now())
+	if err != nil {
+		return err
+	}
+	f.t, f.set = t, true
+	return nil
+}
+
+// or returns the flag's time, or def when it wasn't set
+func (f *timeFlag) or(def time.Time) time.Time {
+	if f.set {
+		return f.t
+	}
+	return def
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{in: "now", want: now},
+		{in: "2021-05-01T00:00:00Z", want: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "2021-05-01T00:00:00.5+02:00", want: time.Date(2021, 4, 30, 22, 0, 0, 5e8, time.UTC)},
+		{in: "1622548800000000000", want: time.Unix(0, 1622548800000000000)},
+		{in: "0", want: time.Unix(0, 0)},
+		// negative integers are timestamps before 1970, not durations
+		{in: "-86400000000000", want: time.Unix(0, -86400000000000)},
+		{in: "-9223372036854775808", want: time.Unix(0, -9223372036854775808)},
+		{in: "-1h", want: now.Add(-time.Hour)},
+		{in: "+15m", want: now.Add(15 * time.Minute)},
+		{in: "-7d", want: now.Add(-7 * 24 * time.Hour)},
+		{in: "-1.5d", want: now.Add(-36 * time.Hour)},
+		{in: "-1d12h", want: now.Add(-36 * time.Hour)},
+		{in: "+2d30m15s", want: now.Add(48*time.Hour + 30*time.Minute + 15*time.Second)},
+		{in: "-1h30m", want: now.Add(-90 * time.Minute)},
+		{in: "-d", err: true},
+		{in: "-1d-2h", err: true},
+		{in: "-1dx", err: true},
+		{in: "-xd", err: true},
+		{in: "-1", want: time.Unix(0, -1)},
+		{in: "-1x", err: true},
+		{in: "yesterday", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTime(tt.in, now)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{in: "1h", want: time.Hour},
+		{in: "1d", want: 24 * time.Hour},
+		{in: "1d12h", want: 36 * time.Hour},
+		{in: "0.5d", want: 12 * time.Hour},
+		{in: "1d1d", err: true},
+		{in: "d", err: true},
+		{in: "1", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if tt.err != (err != nil) || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Command appenddb-server runs the reference append-only database server
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	"github.com/r-coffee/db-append-only-sdk/server/segment"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	var (
+		port     = flag.Int("port", 7777, "port to listen on")
+		certFile = flag.String("cert", "", "path to the server's PEM encoded certificate")
+		keyFile  = flag.String("key", "", "path to the server's PEM encoded private key")
+		clientCA = flag.String("client-ca", "", "path to a PEM bundle of CAs, clients must present a certificate signed by one of them")
+		insecure = flag.Bool("insecure", false, "serve plaintext without tls, for local development only")
+		dataDir  = flag.String("data", "", "directory to store tables in, tables are kept in memory when empty")
+		fsync    = flag.String("fsync", "always", "when to sync appends to disk: always, interval or never")
+		syncInt  = flag.Duration("fsync-interval", time.Second, "how often to sync with -fsync interval")
+		segSize  = flag.Int64("segment-size", 64<<20, "roll over to a new segment file after this many bytes")
+		segAge   = flag.Duration("segment-age", 0, "roll over to a new segment file after this long, 0 disables it")
+	)
+	flag.Parse()
+
+	var opts []grpc.ServerOption
+	if !*insecure {
+		creds, err := serverCredentials(*certFile, *keyFile, *clientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var store server.Storage = server.NewMemoryStorage()
+	if *dataDir != "" {
+		policy, err := segment.ParseSyncPolicy(*fsync)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if policy == segment.SyncInterval && *syncInt <= 0 {
+			log.Fatal("-fsync-interval must be positive")
+		}
+		segStore, err := segment.Open(*dataDir,
+			segment.WithSyncPolicy(policy),
+			segment.WithSyncInterval(*syncInt),
+			segment.WithMaxSegmentSize(*segSize),
+			segment.WithMaxSegmentAge(*segAge))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer segStore.Close()
+		store = segStore
+	}
+
+	srv := grpc.NewServer(opts...)
+	proto.RegisterDBServiceServer(srv, server.New(store))
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Print("shutting down")
+		srv.GracefulStop()
+	}()
+
+	log.Printf("listening on %s", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Print(err)
+	}
+}
+
+// serverCredentials loads the server's key pair and, for mutual tls, the CAs trusted to sign client certificates
+func serverCredentials(certFile, keyFile, clientCA string) (credentials.TransportCredentials, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("-cert and -key are required unless -insecure is set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
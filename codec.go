@@ -0,0 +1,60 @@
+package dbsdk
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Codec converts values to and from the bytes stored in a row
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(dat []byte) (T, error)
+}
+
+// JSONCodec stores values as JSON
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(dat []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(dat, &v)
+	return v, err
+}
+
+// GobCodec stores values with encoding/gob, every row carries its own type information
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(dat []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(dat)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec stores protobuf messages in their binary wire format
+// T is the generated message pointer type, for example *mypb.Reading
+type ProtoCodec[T protobuf.Message] struct{}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return protobuf.Marshal(v)
+}
+
+func (ProtoCodec[T]) Decode(dat []byte) (T, error) {
+	var zero T
+	v := zero.ProtoReflect().New().Interface().(T)
+	err := protobuf.Unmarshal(dat, v)
+	return v, err
+}
@@ -0,0 +1,256 @@
+// Package dbsdktest runs an in-memory DBService for the unit tests of code using the sdk
+//
+//	srv, client := dbsdktest.Start(t)
+//	srv.FailNext("Append", 1, status.Error(codes.Unavailable, "restarting"))
+//	err := client.Append("table", time.Now(), []byte("row"))
+//	rows := srv.Rows("table")
+package dbsdktest
+
+import (
+	"context"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+// servers numbers the servers, so the clients of different servers dial different targets
+var servers uint64
+
+// Server is an in-memory DBService reachable over an in-process connection
+type Server struct {
+	store  *server.MemoryStorage
+	srv    *grpc.Server
+	lis    *bufconn.Listener
+	target string
+
+	mu     sync.Mutex
+	faults map[string]*fault
+	calls  map[string]int
+}
+
+// fault is what happens to the calls of a method
+type fault struct {
+	err error
+	// remaining calls to fail, negative fails until Reset
+	remaining int
+	latency   time.Duration
+}
+
+// NewServer starts a server, Close stops it
+func NewServer(opts ...server.Option) *Server {
+	s := &Server{
+		store:  server.NewMemoryStorage(),
+		lis:    bufconn.Listen(bufSize),
+		target: "bufnet-" + strconv.FormatUint(atomic.AddUint64(&servers, 1), 10),
+		faults: make(map[string]*fault),
+		calls:  make(map[string]int),
+	}
+	s.srv = grpc.NewServer(grpc.UnaryInterceptor(s.unary), grpc.StreamInterceptor(s.stream))
+	proto.RegisterDBServiceServer(s.srv, server.New(s.store, opts...))
+	go s.srv.Serve(s.lis)
+	return s
+}
+
+// Start starts a server and a client connected to it, both are closed when the test ends
+func Start(tb testing.TB, opts ...dbsdk.Option) (*Server, *dbsdk.AppendDbSDKClient) {
+	tb.Helper()
+
+	s := NewServer()
+	tb.Cleanup(s.Close)
+
+	client, err := s.Client(context.Background(), opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return s, client
+}
+
+// Client connects a new client to the server
+func (s *Server) Client(ctx context.Context, opts ...dbsdk.Option) (*dbsdk.AppendDbSDKClient, error) {
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return s.lis.Dial()
+	}
+	opts = append([]dbsdk.Option{
+		dbsdk.WithInsecure(),
+		dbsdk.WithDialOptions(grpc.WithContextDialer(dialer)),
+	}, opts...)
+	return dbsdk.NewClient(ctx, s.target, opts...)
+}
+
+// Conn opens a plain grpc connection to the server, for testing the rpcs without the client
+// opts are added to the dial options, for example an interceptor under test
+func (s *Server) Conn(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return s.lis.Dial()
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer),
+	}, opts...)
+	return grpc.DialContext(ctx, s.target, opts...)
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.srv.Stop()
+}
+
+// Fail makes every call to method fail with err until Reset
+// method is the rpc name, for example "Append" or "Query", an empty method matches all of them
+// Streams already open fail at the next message the server sends
+func (s *Server) Fail(method string, err error) {
+	s.FailNext(method, -1, err)
+}
+
+// FailNext makes the next n calls to method fail with err, the messages sent on open streams count towards n
+// Use a status error, for example status.Error(codes.Unavailable, "down"), to control the code the client sees
+func (s *Server) FailNext(method string, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.fault(method)
+	f.err, f.remaining = err, n
+}
+
+// SetLatency delays every call to method by d until Reset
+func (s *Server) SetLatency(method string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fault(method).latency = d
+}
+
+// Reset removes every injected error and latency
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = make(map[string]*fault)
+}
+
+// Calls returns how many times method was called, including the calls that were failed
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+// Rows returns the rows stored in a table in timestamp order
+func (s *Server) Rows(table string) []*proto.DBTuple {
+	it, err := s.store.Scan(table, math.MinInt64, math.MaxInt64, false)
+	if err != nil {
+		return nil
+	}
+	defer it.Close()
+
+	var rows []*proto.DBTuple
+	for it.Next() {
+		rows = append(rows, it.Row())
+	}
+	return rows
+}
+
+// Tables returns the names of the tables in the server
+func (s *Server) Tables() []string {
+	tables, _ := s.store.Tables()
+	return tables
+}
+
+// fault returns the fault of method, the caller holds s.mu
+func (s *Server) fault(method string) *fault {
+	f, ok := s.faults[method]
+	if !ok {
+		f = &fault{}
+		s.faults[method] = f
+	}
+	return f
+}
+
+// intercept records a call to the rpc and applies its faults
+func (s *Server) intercept(ctx context.Context, fullMethod string) error {
+	method := path.Base(fullMethod)
+
+	s.mu.Lock()
+	s.calls[method]++
+	latency, err := s.take(method)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// take returns the latency and the error, if any, of the next call to method, the caller holds s.mu
+func (s *Server) take(method string) (time.Duration, error) {
+	var latency time.Duration
+	var err error
+	for _, name := range []string{"", method} {
+		f, ok := s.faults[name]
+		if !ok {
+			continue
+		}
+		latency += f.latency
+		if err == nil && f.err != nil && f.remaining != 0 {
+			err = f.err
+			if f.remaining > 0 {
+				f.remaining--
+			}
+		}
+	}
+	return latency, err
+}
+
+func (s *Server) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.intercept(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.intercept(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &faultStream{ServerStream: ss, srv: s, method: path.Base(info.FullMethod)})
+}
+
+// faultStream applies the errors injected after a stream was opened to the messages it sends,
+// so a test can break a stream part way through
+type faultStream struct {
+	grpc.ServerStream
+	srv    *Server
+	method string
+}
+
+func (f *faultStream) SendMsg(m interface{}) error {
+	f.srv.mu.Lock()
+	_, err := f.srv.take(f.method)
+	f.srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return f.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,99 @@
+package dbsdk
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sentinel errors matched by the errors returned from the client, use errors.Is to test for them
+var (
+	ErrTableNotFound     = errors.New("table not found")
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrAlreadyExists     = errors.New("already exists")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrUnauthenticated   = errors.New("unauthenticated")
+	ErrResourceExhausted = errors.New("resource exhausted")
+	ErrUnavailable       = errors.New("server unavailable")
+	ErrDeadlineExceeded  = errors.New("deadline exceeded")
+	ErrCanceled          = errors.New("canceled")
+	ErrUnimplemented     = errors.New("not implemented by the server")
+	ErrInternal          = errors.New("internal server error")
+)
+
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:          ErrTableNotFound,
+	codes.InvalidArgument:   ErrInvalidArgument,
+	codes.OutOfRange:        ErrInvalidArgument,
+	codes.AlreadyExists:     ErrAlreadyExists,
+	codes.PermissionDenied:  ErrPermissionDenied,
+	codes.Unauthenticated:   ErrUnauthenticated,
+	codes.ResourceExhausted: ErrResourceExhausted,
+	codes.Unavailable:       ErrUnavailable,
+	codes.DeadlineExceeded:  ErrDeadlineExceeded,
+	codes.Canceled:          ErrCanceled,
+	codes.Unimplemented:     ErrUnimplemented,
+	codes.Internal:          ErrInternal,
+	codes.DataLoss:          ErrInternal,
+	codes.Unknown:           ErrInternal,
+}
+
+// Error describes a failed request, use errors.As to inspect it
+type Error struct {
+	// Method is the name of the rpc that failed, for example "Query"
+	Method string
+	// Table is the table the request was about, empty for requests like ListTables
+	Table string
+	// Code and Message come from the server's status
+	Code    codes.Code
+	Message string
+	// Err is the sentinel error matching Code, nil when there is none
+	Err error
+
+	status *status.Status
+}
+
+func (e *Error) Error() string {
+	what := e.Method
+	if e.Table != "" {
+		what = fmt.Sprintf("%s %s", e.Method, e.Table)
+	}
+
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s: %s", what, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", what, e.Err, e.Message)
+}
+
+// Unwrap returns the sentinel error matching the status code
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus keeps status.Code and status.FromError working on the error
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// wrapErr turns a grpc status error into an *Error, other errors are returned as is
+func wrapErr(method, table string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	return &Error{
+		Method:  method,
+		Table:   table,
+		Code:    st.Code(),
+		Message: st.Message(),
+		Err:     codeErrors[st.Code()],
+		status:  st,
+	}
+}
@@ -0,0 +1,82 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrors(t *testing.T) {
+	sentinels := []error{
+		dbsdk.ErrTableNotFound, dbsdk.ErrInvalidArgument, dbsdk.ErrAlreadyExists, dbsdk.ErrPermissionDenied,
+		dbsdk.ErrUnauthenticated, dbsdk.ErrResourceExhausted, dbsdk.ErrUnavailable, dbsdk.ErrDeadlineExceeded,
+		dbsdk.ErrCanceled, dbsdk.ErrUnimplemented, dbsdk.ErrInternal,
+	}
+	tests := []struct {
+		code codes.Code
+		want error
+	}{
+		{codes.NotFound, dbsdk.ErrTableNotFound},
+		{codes.InvalidArgument, dbsdk.ErrInvalidArgument},
+		{codes.OutOfRange, dbsdk.ErrInvalidArgument},
+		{codes.AlreadyExists, dbsdk.ErrAlreadyExists},
+		{codes.PermissionDenied, dbsdk.ErrPermissionDenied},
+		{codes.Unauthenticated, dbsdk.ErrUnauthenticated},
+		{codes.ResourceExhausted, dbsdk.ErrResourceExhausted},
+		{codes.Unavailable, dbsdk.ErrUnavailable},
+		{codes.DeadlineExceeded, dbsdk.ErrDeadlineExceeded},
+		{codes.Canceled, dbsdk.ErrCanceled},
+		{codes.Unimplemented, dbsdk.ErrUnimplemented},
+		{codes.Internal, dbsdk.ErrInternal},
+		{codes.DataLoss, dbsdk.ErrInternal},
+		{codes.Unknown, dbsdk.ErrInternal},
+		{codes.FailedPrecondition, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			srv.FailNext("Stats", 1, status.Error(tt.code, "injected"))
+			_, err := client.StatsContext(context.Background(), "t")
+
+			for _, sentinel := range sentinels {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, sentinel, got)
+				}
+			}
+
+			var e *dbsdk.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("%v is not a *dbsdk.Error", err)
+			}
+			if e.Method != "Stats" || e.Table != "t" || e.Code != tt.code || e.Message != "injected" || e.Err != tt.want {
+				t.Errorf("got %+v", e)
+			}
+
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("status.Code = %s, want %s", code, tt.code)
+			}
+			if st, ok := status.FromError(err); !ok || st.Message() != "injected" {
+				t.Errorf("status.FromError = %v, %v", st, ok)
+			}
+		})
+	}
+}
+
+func TestErrorsWithoutTable(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	srv.FailNext("ListTables", 1, status.Error(codes.Unavailable, "down"))
+	_, err := client.ListTables()
+
+	var e *dbsdk.Error
+	if !errors.As(err, &e) || e.Method != "ListTables" || e.Table != "" {
+		t.Fatalf("got %#v", err)
+	}
+	if want := "ListTables: server unavailable: down"; err.Error() != want {
+		t.Fatalf("got %q, want %q", err.Error(), want)
+	}
+}
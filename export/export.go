@@ -0,0 +1,270 @@
+// Package export streams the rows of a table into files for tools outside the database,
+// as JSON Lines, CSV or Parquet
+package export
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	// JSONL writes one {"ts": ..., "data": ...} object per line
+	JSONL Format = "jsonl"
+	// CSV writes a ts,data header followed by one record per row
+	CSV Format = "csv"
+	// Parquet writes a ts column of nanosecond UTC timestamps and a binary data column
+	Parquet Format = "parquet"
+)
+
+// FormatForPath picks the format from the extension of path, JSONL when it is not known
+func FormatForPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CSV
+	case ".parquet", ".pq":
+		return Parquet
+	default:
+		return JSONL
+	}
+}
+
+// Encoding is how row data is written in the text formats
+type Encoding string
+
+const (
+	Base64 Encoding = "base64"
+	Hex    Encoding = "hex"
+	// UTF8 writes the data as is, it should only be used for tables of text
+	// A row whose data isn't valid UTF-8, or in CSV holds a \r\n, fails the export
+	UTF8 Encoding = "utf8"
+)
+
+// Option configures an export
+type Option func(*options)
+
+type options struct {
+	format       Format
+	encoding     Encoding
+	split        time.Duration
+	rowGroupSize int
+}
+
+// WithFormat sets the file format, the default is JSONL or for Files the one of the path extension
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithEncoding sets how row data is written in JSONL and CSV, the default is Base64
+// Parquet always stores the data as raw bytes
+func WithEncoding(encoding Encoding) Option {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
+
+// WithSplit makes Files start a new file for every window of d, windows are aligned to UTC
+func WithSplit(d time.Duration) Option {
+	return func(o *options) {
+		o.split = d
+	}
+}
+
+// WithRowGroupSize sets the most rows buffered into one Parquet row group, the default is 131072
+func WithRowGroupSize(rows int) Option {
+	return func(o *options) {
+		o.rowGroupSize = rows
+	}
+}
+
+func newOptions(opts []Option, format Format) (options, error) {
+	o := options{format: format, encoding: Base64, rowGroupSize: 128 << 10}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	switch o.format {
+	case JSONL, CSV, Parquet:
+	default:
+		return o, fmt.Errorf("export: unknown format %q", o.format)
+	}
+	switch o.encoding {
+	case Base64, Hex, UTF8:
+	default:
+		return o, fmt.Errorf("export: unknown encoding %q", o.encoding)
+	}
+	if o.split < 0 {
+		return o, fmt.Errorf("export: negative split %s", o.split)
+	}
+	if o.rowGroupSize <= 0 {
+		return o, fmt.Errorf("export: row group size must be positive")
+	}
+	return o, nil
+}
+
+// Writer encodes rows in an export format
+type Writer interface {
+	// Write adds a row to the output
+	Write(row *proto.DBTuple) error
+	// Close writes anything still buffered, it does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a Writer for the format in opts that writes to w
+func NewWriter(w io.Writer, opts ...Option) (Writer, error) {
+	o, err := newOptions(opts, JSONL)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(w, o), nil
+}
+
+func newWriter(w io.Writer, o options) Writer {
+	switch o.format {
+	case CSV:
+		return newCSVWriter(w, o.encoding)
+	case Parquet:
+		return newParquetWriter(w, o.rowGroupSize)
+	default:
+		return newJSONLWriter(w, o.encoding)
+	}
+}
+
+// Table will stream the rows of table between start and stop inclusive into w
+// It returns the number of rows written, WithSplit does not apply
+func Table(ctx context.Context, client *dbsdk.AppendDbSDKClient, table string, start, stop time.Time, w io.Writer, opts ...Option) (int64, error) {
+	o, err := newOptions(opts, JSONL)
+	if err != nil {
+		return 0, err
+	}
+
+	it := client.Scan(ctx, table, start, stop)
+	defer it.Close()
+
+	out := newWriter(w, o)
+	var n int64
+	for it.Next() {
+		if err := out.Write(it.Row()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+	return n, out.Close()
+}
+
+// Files will export the rows of table between start and stop inclusive to path
+// With WithSplit the rows go to one file per window instead, named after the start
+// of the window like data-20240102T150000Z.csv for path data.csv, windows without
+// rows get no file. It returns the paths of the files that were written
+func Files(ctx context.Context, client *dbsdk.AppendDbSDKClient, table string, start, stop time.Time, path string, opts ...Option) ([]string, error) {
+	o, err := newOptions(opts, FormatForPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	it := client.Scan(ctx, table, start, stop)
+	defer it.Close()
+
+	var (
+		paths  []string
+		f      *file
+		window time.Time
+	)
+	defer func() {
+		if f != nil {
+			f.abort()
+		}
+	}()
+
+	if o.split == 0 {
+		if f, err = createFile(path, o); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	for it.Next() {
+		row := it.Row()
+		if o.split > 0 {
+			// a scan is in timestamp order so every window is visited once
+			if w := time.Unix(0, row.Ts).UTC().Truncate(o.split); f == nil || !w.Equal(window) {
+				if f != nil {
+					err, f = f.close(), nil
+					if err != nil {
+						return paths, err
+					}
+				}
+				window = w
+				name := splitPath(path, window)
+				if f, err = createFile(name, o); err != nil {
+					return paths, err
+				}
+				paths = append(paths, name)
+			}
+		}
+		if err := f.out.Write(row); err != nil {
+			return paths, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return paths, err
+	}
+
+	if f != nil {
+		err, f = f.close(), nil
+	}
+	return paths, err
+}
+
+func splitPath(path string, window time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + window.Format("20060102T150405Z") + ext
+}
+
+// file is an export being written to disk
+type file struct {
+	f   *os.File
+	buf *bufio.Writer
+	out Writer
+}
+
+func createFile(path string, o options) (*file, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriterSize(f, 1<<20)
+	return &file{f: f, buf: buf, out: newWriter(buf, o)}, nil
+}
+
+func (f *file) close() error {
+	err := f.out.Close()
+	if err == nil {
+		err = f.buf.Flush()
+	}
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// abort closes a file after a failed export, what was written so far is left on disk
+func (f *file) abort() {
+	f.f.Close()
+}
@@ -0,0 +1,249 @@
+package export
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// parquet enums used by the writer
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetPlain    = 0
+	parquetRLE      = 3
+
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// rowGroupBytes caps the data buffered in one row group, a page size has to fit an int32
+const rowGroupBytes = 64 << 20
+
+var parquetMagic = []byte("PAR1")
+
+// parquetWriter writes the rows as a parquet file of two required columns, ts as
+// an int64 nanosecond UTC timestamp and data as a byte array, each row group holds
+// one uncompressed PLAIN page per column
+type parquetWriter struct {
+	w      io.Writer
+	offset int64
+	err    error
+
+	rowGroupSize int
+	ts           []int64
+	data         [][]byte
+	size         int
+
+	rowGroups []parquetRowGroup
+	rows      int64
+}
+
+type parquetColumn struct {
+	offset int64
+	size   int64
+	stats  bool
+	min    int64
+	max    int64
+}
+
+type parquetRowGroup struct {
+	rows    int64
+	columns [2]parquetColumn
+}
+
+func newParquetWriter(w io.Writer, rowGroupSize int) *parquetWriter {
+	return &parquetWriter{w: w, rowGroupSize: rowGroupSize}
+}
+
+func (w *parquetWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.offset += int64(n)
+}
+
+func (w *parquetWriter) Write(row *proto.DBTuple) error {
+	if w.offset == 0 {
+		w.write(parquetMagic)
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	if len(w.ts) > 0 && (len(w.ts) >= w.rowGroupSize || w.size+4+len(row.Data) > rowGroupBytes) {
+		w.flush()
+	}
+	w.ts = append(w.ts, row.Ts)
+	w.data = append(w.data, row.Data)
+	w.size += 4 + len(row.Data)
+	return w.err
+}
+
+// flush writes the buffered rows as a row group
+func (w *parquetWriter) flush() {
+	rg := parquetRowGroup{rows: int64(len(w.ts))}
+
+	page := make([]byte, 8*len(w.ts))
+	rg.columns[0] = parquetColumn{stats: true, min: math.MaxInt64, max: math.MinInt64}
+	for i, ts := range w.ts {
+		binary.LittleEndian.PutUint64(page[8*i:], uint64(ts))
+		if ts < rg.columns[0].min {
+			rg.columns[0].min = ts
+		}
+		if ts > rg.columns[0].max {
+			rg.columns[0].max = ts
+		}
+	}
+	rg.columns[0].offset, rg.columns[0].size = w.page(page, len(w.ts))
+
+	page = make([]byte, w.size)
+	pos := 0
+	for _, dat := range w.data {
+		binary.LittleEndian.PutUint32(page[pos:], uint32(len(dat)))
+		pos += 4 + copy(page[pos+4:], dat)
+	}
+	rg.columns[1].offset, rg.columns[1].size = w.page(page, len(w.data))
+
+	w.rowGroups = append(w.rowGroups, rg)
+	w.rows += rg.rows
+	for i := range w.data {
+		w.data[i] = nil
+	}
+	w.ts, w.data, w.size = w.ts[:0], w.data[:0], 0
+}
+
+// page writes a data page and returns where it starts and its size with the header
+func (w *parquetWriter) page(dat []byte, values int) (int64, int64) {
+	var t thriftWriter
+	t.begin(0)
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(len(dat)))
+	t.i32(3, int32(len(dat)))
+	t.begin(5)
+	t.i32(1, int32(values))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+	t.end()
+
+	offset := w.offset
+	w.write(t.buf.Bytes())
+	w.write(dat)
+	return offset, int64(t.buf.Len() + len(dat))
+}
+
+// Close writes the last row group and the file footer
+func (w *parquetWriter) Close() error {
+	if w.offset == 0 {
+		w.write(parquetMagic)
+	}
+	if len(w.ts) > 0 {
+		w.flush()
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	footer := w.footer()
+	w.write(footer)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	w.write(size[:])
+	w.write(parquetMagic)
+	return w.err
+}
+
+func (w *parquetWriter) footer() []byte {
+	var t thriftWriter
+	t.begin(0)
+	t.i32(1, 1)
+
+	t.list(2, thriftStruct, 3)
+	t.begin(0)
+	t.string(4, "schema")
+	t.i32(5, 2)
+	t.end()
+	t.begin(0)
+	t.i32(1, parquetInt64)
+	t.i32(3, parquetRequired)
+	t.string(4, "ts")
+	// logical type TIMESTAMP(isAdjustedToUTC=true, unit=NANOS)
+	t.begin(10)
+	t.begin(8)
+	t.bool(1, true)
+	t.begin(2)
+	t.begin(3)
+	t.end()
+	t.end()
+	t.end()
+	t.end()
+	t.end()
+	t.begin(0)
+	t.i32(1, parquetByteArray)
+	t.i32(3, parquetRequired)
+	t.string(4, "data")
+	t.end()
+
+	t.i64(3, w.rows)
+
+	t.list(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		t.begin(0)
+		t.list(1, thriftStruct, len(rg.columns))
+		var total int64
+		for i, col := range rg.columns {
+			t.begin(0)
+			t.i64(2, col.offset)
+			t.begin(3)
+			t.i32(1, [2]int32{parquetInt64, parquetByteArray}[i])
+			t.list(2, thriftI32, 1)
+			t.i32Elem(parquetPlain)
+			t.list(3, thriftBinary, 1)
+			t.stringElem([2]string{"ts", "data"}[i])
+			t.i32(4, parquetUncompressed)
+			t.i64(5, rg.rows)
+			t.i64(6, col.size)
+			t.i64(7, col.size)
+			t.i64(9, col.offset)
+			if col.stats {
+				// the legacy min and max agree with min_value and max_value for signed ints
+				var min, max [8]byte
+				binary.LittleEndian.PutUint64(min[:], uint64(col.min))
+				binary.LittleEndian.PutUint64(max[:], uint64(col.max))
+				t.begin(12)
+				t.binary(1, max[:])
+				t.binary(2, min[:])
+				t.binary(5, max[:])
+				t.binary(6, min[:])
+				t.end()
+			}
+			t.end()
+			t.end()
+			total += col.size
+		}
+		t.i64(2, total)
+		t.i64(3, rg.rows)
+		t.end()
+	}
+
+	t.string(6, "db-append-only-sdk export")
+
+	// both columns use the natural order of their type for statistics
+	t.list(7, thriftStruct, 2)
+	for i := 0; i < 2; i++ {
+		t.begin(0)
+		t.begin(1)
+		t.end()
+		t.end()
+	}
+	t.end()
+	return t.buf.Bytes()
+}
@@ -0,0 +1,232 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// thriftReader decodes the compact protocol into maps of field id to value,
+// lists become []interface{} and structs map[int16]interface{}
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		v := r.buf[r.pos : r.pos+n]
+		r.pos += n
+		return v
+	case thriftList:
+		h := r.byte()
+		n, elem := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		fields[id] = r.value(h & 0x0f)
+	}
+}
+
+type parquetFile struct {
+	meta      map[int16]interface{}
+	rowGroups int
+	ts        []int64
+	data      [][]byte
+}
+
+// readParquet checks the layout of a file written by parquetWriter and reads its rows back
+func readParquet(t *testing.T, file []byte) parquetFile {
+	t.Helper()
+	if len(file) < 12 || !bytes.Equal(file[:4], parquetMagic) || !bytes.Equal(file[len(file)-4:], parquetMagic) {
+		t.Fatalf("missing PAR1 magic")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{buf: file[len(file)-8-size : len(file)-8]}
+	meta := footer.structure()
+	if footer.pos != size {
+		t.Fatalf("footer is %d bytes, decoded %d", size, footer.pos)
+	}
+
+	schema := meta[2].([]interface{})
+	if len(schema) != 3 {
+		t.Fatalf("expected a root and 2 columns, got %d schema elements", len(schema))
+	}
+	for i, want := range []struct {
+		name string
+		typ  int64
+	}{{"ts", parquetInt64}, {"data", parquetByteArray}} {
+		col := schema[i+1].(map[int16]interface{})
+		if string(col[4].([]byte)) != want.name || col[1].(int64) != want.typ || col[3].(int64) != parquetRequired {
+			t.Fatalf("column %d: %v", i, col)
+		}
+	}
+
+	pf := parquetFile{meta: meta}
+	var rows int64
+	for _, g := range meta[4].([]interface{}) {
+		rg := g.(map[int16]interface{})
+		pf.rowGroups++
+		n := rg[3].(int64)
+		rows += n
+
+		var total int64
+		for i, c := range rg[1].([]interface{}) {
+			cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+			offset, chunkSize := cm[9].(int64), cm[7].(int64)
+			total += chunkSize
+			if cm[5].(int64) != n {
+				t.Fatalf("column %d has %d values in a group of %d rows", i, cm[5], n)
+			}
+
+			page := &thriftReader{buf: file[offset : offset+chunkSize]}
+			ph := page.structure()
+			dat := page.buf[page.pos:]
+			if int64(page.pos)+ph[3].(int64) != chunkSize || int64(len(dat)) != ph[2].(int64) {
+				t.Fatalf("column %d: page sizes don't add up to the chunk", i)
+			}
+			if ph[5].(map[int16]interface{})[1].(int64) != n {
+				t.Fatalf("column %d: page has %v values, expected %d", i, ph[5], n)
+			}
+
+			if i == 0 {
+				var min, max int64
+				for j := 0; j < int(n); j++ {
+					ts := int64(binary.LittleEndian.Uint64(dat[8*j:]))
+					if j == 0 || ts < min {
+						min = ts
+					}
+					if j == 0 || ts > max {
+						max = ts
+					}
+					pf.ts = append(pf.ts, ts)
+				}
+				stats := cm[12].(map[int16]interface{})
+				if int64(binary.LittleEndian.Uint64(stats[6].([]byte))) != min || int64(binary.LittleEndian.Uint64(stats[5].([]byte))) != max {
+					t.Fatalf("ts statistics don't match the page")
+				}
+				continue
+			}
+			for len(dat) > 0 {
+				l := binary.LittleEndian.Uint32(dat)
+				pf.data = append(pf.data, dat[4:4+l])
+				dat = dat[4+l:]
+			}
+		}
+		if rg[2].(int64) != total {
+			t.Fatalf("row group size %d, columns add up to %d", rg[2], total)
+		}
+	}
+	if meta[3].(int64) != rows {
+		t.Fatalf("file has %d rows, row groups add up to %d", meta[3], rows)
+	}
+	return pf
+}
+
+func TestParquetEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WithFormat(Parquet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pf := readParquet(t, buf.Bytes())
+	if pf.rowGroups != 0 || len(pf.ts) != 0 {
+		t.Fatalf("expected no rows, got %d row groups", pf.rowGroups)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	// 20 row groups also needs the long form of a thrift list header
+	for _, tc := range []struct{ size, groups int }{{7, 3}, {1, 20}, {100, 1}} {
+		t.Run(fmt.Sprint(tc.size), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, WithFormat(Parquet), WithRowGroupSize(tc.size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows []*proto.DBTuple
+			for i := 0; i < 20; i++ {
+				row := &proto.DBTuple{Ts: int64(1000 - i*3), Data: bytes.Repeat([]byte{byte(i)}, i)}
+				rows = append(rows, row)
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			pf := readParquet(t, buf.Bytes())
+			if pf.rowGroups != tc.groups {
+				t.Fatalf("expected %d row groups, got %d", tc.groups, pf.rowGroups)
+			}
+			if len(pf.ts) != len(rows) || len(pf.data) != len(rows) {
+				t.Fatalf("read %d timestamps and %d values, wrote %d rows", len(pf.ts), len(pf.data), len(rows))
+			}
+			for i, row := range rows {
+				if pf.ts[i] != row.Ts || !bytes.Equal(pf.data[i], row.Data) {
+					t.Fatalf("row %d: got %d %x, want %d %x", i, pf.ts[i], pf.data[i], row.Ts, row.Data)
+				}
+			}
+		})
+	}
+}
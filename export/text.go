@@ -0,0 +1,105 @@
+package export
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// encode formats row data in enc, UTF8 fails for data that isn't valid UTF-8 rather
+// than writing a file that can't be imported back as it was
+func encode(enc Encoding, row *proto.DBTuple) (string, error) {
+	switch enc {
+	case Hex:
+		return hex.EncodeToString(row.Data), nil
+	case UTF8:
+		if !utf8.Valid(row.Data) {
+			return "", fmt.Errorf("export: the data of the row at %s is not valid UTF-8, use the base64 or hex encoding", formatTs(row.Ts))
+		}
+		return string(row.Data), nil
+	default:
+		return base64.StdEncoding.EncodeToString(row.Data), nil
+	}
+}
+
+func formatTs(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+}
+
+type jsonlWriter struct {
+	enc      *json.Encoder
+	encoding Encoding
+}
+
+func newJSONLWriter(w io.Writer, encoding Encoding) *jsonlWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlWriter{enc: enc, encoding: encoding}
+}
+
+type jsonlRow struct {
+	Ts   string `json:"ts"`
+	Data string `json:"data"`
+}
+
+func (w *jsonlWriter) Write(row *proto.DBTuple) error {
+	dat, err := encode(w.encoding, row)
+	if err != nil {
+		return err
+	}
+	return w.enc.Encode(jsonlRow{Ts: formatTs(row.Ts), Data: dat})
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w        *csv.Writer
+	encoding Encoding
+	header   bool
+}
+
+func newCSVWriter(w io.Writer, encoding Encoding) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), encoding: encoding}
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.w.Write([]string{"ts", "data"})
+}
+
+func (w *csvWriter) Write(row *proto.DBTuple) error {
+	dat, err := encode(w.encoding, row)
+	if err != nil {
+		return err
+	}
+	// CSV readers turn a quoted \r\n into \n
+	if w.encoding == UTF8 && strings.Contains(dat, "\r\n") {
+		return fmt.Errorf("export: the data of the row at %s has a \\r\\n line ending CSV can't keep, use the base64 or hex encoding", formatTs(row.Ts))
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.w.Write([]string{formatTs(row.Ts), dat})
+}
+
+// Close writes the header for an empty export so every file has one
+func (w *csvWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -1,9 +1,16 @@
 module github.com/r-coffee/db-append-only-sdk
 
-go 1.16
+go 1.18
 
 require (
 	github.com/golang/protobuf v1.5.2
 	google.golang.org/grpc v1.38.0
 	google.golang.org/protobuf v1.26.0
 )
+
+require (
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+)
@@ -0,0 +1,36 @@
+package dbsdk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type idempotencyKeyCtx struct{}
+
+// ContextWithIdempotencyKey makes the append sent with ctx use key instead of a generated one
+// Reuse the same key only when retrying the same append whose outcome is unknown, for example
+// after a timeout. A key must not be reused for a different table or different rows,
+// the server rejects a key that comes back with different rows
+// The Writer ignores the key and gives every batch its own
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// idempotencyKey returns the key set on ctx or a new random one
+func idempotencyKey(ctx context.Context) string {
+	if key, ok := ctx.Value(idempotencyKeyCtx{}).(string); ok && key != "" {
+		return key
+	}
+	return newIdempotencyKey()
+}
+
+// newIdempotencyKey returns a random key
+func newIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// without a key the request is still sent, it just won't be retried
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
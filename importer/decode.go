@@ -0,0 +1,226 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/export"
+)
+
+// ParseError is a line of the input that could not be turned into a row
+type ParseError struct {
+	Line int64
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// source reads lines and keeps track of how far into the file they are
+type source struct {
+	r      *bufio.Reader
+	offset int64
+	line   int64
+}
+
+func newSource(r io.Reader, offset, line int64) *source {
+	return &source{r: bufio.NewReaderSize(r, 1<<20), offset: offset, line: line}
+}
+
+// readLine returns the next line with its line ending, or io.EOF at the end of the input
+func (s *source) readLine() ([]byte, error) {
+	dat, err := s.r.ReadBytes('\n')
+	if len(dat) > 0 {
+		s.offset += int64(len(dat))
+		s.line++
+		return dat, nil
+	}
+	return nil, err
+}
+
+// decoder turns the input into rows, the source offset is at the end of the last row returned
+type decoder interface {
+	next() (dbsdk.Row, error)
+}
+
+// rowDecoder holds what the JSONL and CSV decoders share
+type rowDecoder struct {
+	src      *source
+	encoding export.Encoding
+	min, max time.Time
+}
+
+func (d *rowDecoder) row(ts, data string) (dbsdk.Row, error) {
+	t, err := parseTs(ts)
+	if err != nil {
+		return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: err}
+	}
+	if (!d.min.IsZero() && t.Before(d.min)) || (!d.max.IsZero() && t.After(d.max)) {
+		return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: fmt.Errorf("timestamp %s is outside of the allowed range", t.Format(time.RFC3339Nano))}
+	}
+
+	var dat []byte
+	switch d.encoding {
+	case export.Hex:
+		dat, err = hex.DecodeString(data)
+	case export.UTF8:
+		dat = []byte(data)
+	default:
+		dat, err = base64.StdEncoding.DecodeString(data)
+	}
+	if err != nil {
+		return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: fmt.Errorf("data: %w", err)}
+	}
+	return dbsdk.Row{Ts: t, Data: dat}, nil
+}
+
+// parseTs accepts RFC3339 timestamps and unix nanoseconds
+func parseTs(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ns), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	// a row stores unix nanoseconds, which only cover the years 1678 to 2262
+	if t.Year() < 1678 || t.Year() > 2261 {
+		return time.Time{}, fmt.Errorf("timestamp %q can't be stored", s)
+	}
+	return t, nil
+}
+
+// jsonlDecoder reads lines written by export.JSONL, ts may also be a number of unix nanoseconds
+type jsonlDecoder struct {
+	rowDecoder
+}
+
+func (d *jsonlDecoder) next() (dbsdk.Row, error) {
+	for {
+		line, err := d.src.readLine()
+		if err != nil {
+			return dbsdk.Row{}, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec struct {
+			Ts   json.RawMessage `json:"ts"`
+			Data *string         `json:"data"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: err}
+		}
+		if rec.Data == nil {
+			return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: errors.New("missing data")}
+		}
+		ts := string(rec.Ts)
+		if unquoted, err := strconv.Unquote(ts); err == nil {
+			ts = unquoted
+		}
+		return d.row(ts, *rec.Data)
+	}
+}
+
+// csvDecoder reads records written by export.CSV, the ts and data columns are found
+// by the header and without one the first two columns are used
+type csvDecoder struct {
+	rowDecoder
+	tsCol, dataCol int
+}
+
+// record reads the lines of the next record, a quoted field may span several lines
+func (d *csvDecoder) record() ([]string, error) {
+	var raw []byte
+	for {
+		line, err := d.src.readLine()
+		if err != nil {
+			if len(raw) > 0 {
+				return nil, &ParseError{Line: d.src.line, Err: errors.New("unterminated quoted field")}
+			}
+			return nil, err
+		}
+		raw = append(raw, line...)
+		// doubled quotes are escapes, so an odd count means a field is still open
+		if bytes.Count(raw, []byte{'"'})%2 == 0 {
+			break
+		}
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = -1
+	rec, err := r.Read()
+	if err != nil {
+		return nil, &ParseError{Line: d.src.line, Err: err}
+	}
+	return rec, nil
+}
+
+// header reads the first record and uses it as the header when it names the columns
+// It returns whether the record was a header
+func (d *csvDecoder) header() (bool, error) {
+	d.tsCol, d.dataCol = 0, 1
+	rec, err := d.record()
+	if err != nil || rec == nil {
+		return false, err
+	}
+
+	ts, data := -1, -1
+	for i, name := range rec {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "ts":
+			ts = i
+		case "data":
+			data = i
+		}
+	}
+	if ts < 0 && data < 0 {
+		return false, nil
+	}
+	if ts < 0 || data < 0 {
+		return false, &ParseError{Line: d.src.line, Err: errors.New("header needs a ts and a data column")}
+	}
+	d.tsCol, d.dataCol = ts, data
+	return true, nil
+}
+
+func (d *csvDecoder) next() (dbsdk.Row, error) {
+	for {
+		rec, err := d.record()
+		if err != nil {
+			return dbsdk.Row{}, err
+		}
+		if rec == nil {
+			continue
+		}
+		if len(rec) <= d.tsCol || len(rec) <= d.dataCol {
+			return dbsdk.Row{}, &ParseError{Line: d.src.line, Err: fmt.Errorf("expected a ts and a data field, got %d fields", len(rec))}
+		}
+		return d.row(rec[d.tsCol], rec[d.dataCol])
+	}
+}
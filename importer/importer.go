@@ -0,0 +1,397 @@
+// Package importer loads JSONL and CSV files, like the ones written by package export,
+// into a table with batched appends
+//
+// Progress is checkpointed to a sidecar file after every batch so an interrupted
+// import resumes where it stopped instead of starting over
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/export"
+)
+
+const (
+	// batchBytes caps the data sent in one batch, well under the default gRPC message limit
+	batchBytes = 2 << 20
+	// headBytes is how much of the start of the file a checkpoint hashes to recognize it
+	headBytes = 64 << 10
+)
+
+// ErrFileChanged is returned when resuming an import of a file that changed since its
+// checkpoint was written, remove the checkpoint to import the file from the start
+var ErrFileChanged = errors.New("file changed since the checkpoint was written")
+
+// Progress describes how far an import got
+type Progress struct {
+	// Rows is the number of rows imported, including those of earlier runs
+	Rows int64
+	// Skipped is the number of invalid lines that were skipped
+	Skipped int64
+	// Offset is how many bytes of the file have been imported
+	Offset int64
+	// Size is the size of the file
+	Size int64
+	// Elapsed is how long this run has taken
+	Elapsed time.Duration
+	// RowsPerSec is the rate of this run
+	RowsPerSec float64
+}
+
+// Option configures an import
+type Option func(*options)
+
+type options struct {
+	format     export.Format
+	encoding   export.Encoding
+	batchSize  int
+	checkpoint *string
+	min, max   time.Time
+	skip       func(*ParseError)
+	skipSet    bool
+	progress   func(Progress)
+	every      time.Duration
+}
+
+// WithFormat sets the file format, export.JSONL or export.CSV, the default comes from the file extension
+func WithFormat(format export.Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithEncoding sets how row data is encoded in the file, the default is export.Base64
+func WithEncoding(encoding export.Encoding) Option {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
+
+// WithBatchSize sets the most rows sent in one append, the default is 1000
+func WithBatchSize(rows int) Option {
+	return func(o *options) {
+		o.batchSize = rows
+	}
+}
+
+// WithCheckpoint sets the sidecar file progress is saved to, the default is the
+// file path with .checkpoint added, an empty path turns checkpoints off
+func WithCheckpoint(path string) Option {
+	return func(o *options) {
+		o.checkpoint = &path
+	}
+}
+
+// WithTimeRange rejects the rows outside of min and max inclusive, a zero time leaves that side open
+func WithTimeRange(min, max time.Time) Option {
+	return func(o *options) {
+		o.min, o.max = min, max
+	}
+}
+
+// WithSkipInvalid skips the lines that can't be parsed instead of stopping the import
+// report is called for every skipped line and may be nil
+func WithSkipInvalid(report func(*ParseError)) Option {
+	return func(o *options) {
+		o.skip, o.skipSet = report, true
+	}
+}
+
+// WithProgress calls fn at most once per interval while importing, and once at the end
+func WithProgress(interval time.Duration, fn func(Progress)) Option {
+	return func(o *options) {
+		o.every, o.progress = interval, fn
+	}
+}
+
+// checkpoint is the content of the sidecar file
+// size, modTime and head identify the file the offset belongs to, run identifies the
+// import across its resumes
+type checkpoint struct {
+	Table   string `json:"table"`
+	Run     string `json:"run"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Head    string `json:"head"`
+	Offset  int64  `json:"offset"`
+	Line    int64  `json:"line"`
+	Rows    int64  `json:"rows"`
+	Skipped int64  `json:"skipped"`
+}
+
+func readCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(dat, &cp); err != nil {
+		return cp, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint replaces the sidecar file in one rename so it is never half written
+func writeCheckpoint(path string, cp checkpoint) error {
+	dat, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, dat, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// File will import the rows of the file at path into table
+// When a checkpoint from an earlier run exists the import continues after the last
+// batch it recorded, the checkpoint is removed once the whole file is imported
+// Every batch carries an idempotency key made from the run, the file and its place in
+// the file, so a batch sent again after a crash is ignored by a server that still
+// remembers it, while importing the file again from the start sends new keys
+func File(ctx context.Context, client *dbsdk.AppendDbSDKClient, table, path string, opts ...Option) (Progress, error) {
+	o := options{format: export.FormatForPath(path), encoding: export.Base64, batchSize: 1000}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.format != export.JSONL && o.format != export.CSV {
+		return Progress{}, fmt.Errorf("importer: can't import %q files", o.format)
+	}
+	if o.encoding != export.Base64 && o.encoding != export.Hex && o.encoding != export.UTF8 {
+		return Progress{}, fmt.Errorf("importer: unknown encoding %q", o.encoding)
+	}
+	if o.batchSize <= 0 {
+		return Progress{}, errors.New("importer: batch size must be positive")
+	}
+	cpPath := path + ".checkpoint"
+	if o.checkpoint != nil {
+		cpPath = *o.checkpoint
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Progress{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Progress{}, err
+	}
+
+	head, err := hashHead(f)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	var cp checkpoint
+	if cpPath != "" {
+		if cp, err = readCheckpoint(cpPath); err != nil {
+			return Progress{}, err
+		}
+		if cp.Table != "" && cp.Table != table {
+			return Progress{}, fmt.Errorf("importer: checkpoint %s is for table %s", cpPath, cp.Table)
+		}
+		if cp.Table != "" && (cp.Size != info.Size() || cp.ModTime != info.ModTime().UnixNano() || cp.Head != head) {
+			return Progress{}, fmt.Errorf("importer: %s: %w", path, ErrFileChanged)
+		}
+	}
+	if cp.Run == "" {
+		if cp.Run, err = newRunID(); err != nil {
+			return Progress{}, err
+		}
+	}
+	cp.Table, cp.Size, cp.ModTime, cp.Head = table, info.Size(), info.ModTime().UnixNano(), head
+
+	// the header, and a byte order mark, are only read on the first run
+	// but a resumed CSV import still needs the header for the column order
+	start, tsCol, dataCol, err := preamble(f, o.format)
+	if err != nil {
+		return Progress{}, err
+	}
+	if cp.Offset == 0 {
+		cp.Offset = start
+		if start > 0 && o.format == export.CSV {
+			cp.Line = 1
+		}
+	}
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		return Progress{}, err
+	}
+	// the run is saved before the first batch is sent, so after a crash the batch is
+	// sent again with the same key
+	if cpPath != "" {
+		if err := writeCheckpoint(cpPath, cp); err != nil {
+			return Progress{}, err
+		}
+	}
+
+	src := newSource(f, cp.Offset, cp.Line)
+	base := rowDecoder{src: src, encoding: o.encoding, min: o.min, max: o.max}
+	var dec decoder = &jsonlDecoder{base}
+	if o.format == export.CSV {
+		dec = &csvDecoder{rowDecoder: base, tsCol: tsCol, dataCol: dataCol}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	began := time.Now()
+	lastReport := began
+	startRows := cp.Rows
+	progress := func() Progress {
+		p := Progress{Rows: cp.Rows, Skipped: cp.Skipped, Offset: cp.Offset, Size: cp.Size, Elapsed: time.Since(began)}
+		if p.Elapsed > 0 {
+			p.RowsPerSec = float64(cp.Rows-startRows) / p.Elapsed.Seconds()
+		}
+		return p
+	}
+
+	var (
+		rows    []dbsdk.Row
+		size    int
+		skipped int64
+	)
+	flush := func() error {
+		if len(rows) > 0 {
+			key := batchKey(table, abs, cp, src.offset)
+			if err := client.AppendBatch(dbsdk.ContextWithIdempotencyKey(ctx, key), table, rows); err != nil {
+				return fmt.Errorf("batch ending at line %d: %w", src.line, err)
+			}
+		}
+
+		cp.Rows += int64(len(rows))
+		cp.Skipped += skipped
+		cp.Offset, cp.Line = src.offset, src.line
+		rows, size, skipped = rows[:0], 0, 0
+		if cpPath != "" {
+			if err := writeCheckpoint(cpPath, cp); err != nil {
+				return err
+			}
+		}
+
+		if o.progress != nil && time.Since(lastReport) >= o.every {
+			lastReport = time.Now()
+			o.progress(progress())
+		}
+		return nil
+	}
+
+	for {
+		row, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && o.skipSet {
+			if o.skip != nil {
+				o.skip(parseErr)
+			}
+			skipped++
+			continue
+		}
+		if err != nil {
+			return progress(), err
+		}
+
+		rows = append(rows, row)
+		size += len(row.Data)
+		if len(rows) >= o.batchSize || size >= batchBytes {
+			if err := flush(); err != nil {
+				return progress(), err
+			}
+		}
+	}
+	if len(rows) > 0 || skipped > 0 {
+		if err := flush(); err != nil {
+			return progress(), err
+		}
+	}
+
+	if cpPath != "" {
+		if err := os.Remove(cpPath); err != nil && !os.IsNotExist(err) {
+			return progress(), err
+		}
+	}
+	p := progress()
+	if o.progress != nil {
+		o.progress(p)
+	}
+	return p, nil
+}
+
+// preamble finds where the rows of the file start, after a byte order mark and for CSV
+// the header, and which columns hold the timestamp and the data
+func preamble(f *os.File, format export.Format) (int64, int, int, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, 0, err
+	}
+
+	src := newSource(f, 0, 0)
+	if bom, _ := src.r.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		src.r.Discard(3)
+		src.offset = 3
+	}
+	if format != export.CSV {
+		return src.offset, 0, 0, nil
+	}
+
+	start := src.offset
+	dec := csvDecoder{rowDecoder: rowDecoder{src: src}}
+	header, err := dec.header()
+	if err != nil && err != io.EOF {
+		return 0, 0, 0, err
+	}
+	if header {
+		start = src.offset
+	}
+	return start, dec.tsCol, dec.dataCol, nil
+}
+
+// hashHead hashes the start of the file
+func hashHead(f *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, headBytes)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newRunID returns a random identifier for an import started from the beginning of a file
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// batchKey identifies a batch by the run of the checkpoint, the file and the bytes of
+// the file it was read from, which start at the checkpoint's offset
+func batchKey(table, path string, cp checkpoint, end int64) string {
+	h := sha256.New()
+	for _, s := range []string{table, path, cp.Run, cp.Head} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(strconv.FormatInt(cp.Size, 10) + ":" + strconv.FormatInt(cp.Offset, 10) + "-" + strconv.FormatInt(end, 10)))
+	return "import-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
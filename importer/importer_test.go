@@ -0,0 +1,261 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/export"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rowsString(rows []*proto.DBTuple) string {
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%d=%q ", row.Ts, row.Data)
+	}
+	return b.String()
+}
+
+func TestResume(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	ctx := context.Background()
+
+	var content strings.Builder
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(&content, "{\"ts\": %d, \"data\": \"row %d\"}\n", i+1, i)
+	}
+	path := writeFile(t, "rows.jsonl", content.String())
+
+	// the second batch fails, as if the import was interrupted
+	batches := 0
+	p, err := File(ctx, client, "t", path, WithEncoding(export.UTF8), WithBatchSize(10), WithProgress(0, func(Progress) {
+		if batches++; batches == 1 {
+			srv.Fail("BatchAppend", errors.New("down"))
+		}
+	}))
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+	if p.Rows != 10 || len(srv.Rows("t")) != 10 {
+		t.Fatalf("progress reports %d rows, the table has %d", p.Rows, len(srv.Rows("t")))
+	}
+	if _, err := os.Stat(path + ".checkpoint"); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	srv.Reset()
+	p, err = File(ctx, client, "t", path, WithEncoding(export.UTF8), WithBatchSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rows != 25 || p.Offset != p.Size {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	rows := srv.Rows("t")
+	if len(rows) != 25 {
+		t.Fatalf("expected 25 rows, got %s", rowsString(rows))
+	}
+	for i, row := range rows {
+		if row.Ts != int64(i+1) || string(row.Data) != fmt.Sprintf("row %d", i) {
+			t.Fatalf("row %d is %d=%q", i, row.Ts, row.Data)
+		}
+	}
+	if _, err := os.Stat(path + ".checkpoint"); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint left after the import finished: %v", err)
+	}
+}
+
+func TestResumeChangedFile(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	ctx := context.Background()
+	path := writeFile(t, "rows.csv", "ts,data\n1,YQ==\n2,Yg==\n3,Yw==\n")
+
+	// the second batch fails, after the first one was checkpointed
+	fail := WithProgress(0, func(Progress) { srv.Fail("BatchAppend", errors.New("down")) })
+	if _, err := File(ctx, client, "t", path, WithBatchSize(2), fail); err == nil {
+		t.Fatal("expected the import to fail")
+	}
+	srv.Reset()
+
+	// the file is replaced before the import is resumed
+	if err := os.WriteFile(path, []byte("ts,data\n7,YQ==\n8,Yg==\n9,Yw==\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Hour))
+	if _, err := File(ctx, client, "t", path, WithBatchSize(2)); !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("expected ErrFileChanged, got %v", err)
+	}
+	if got := rowsString(srv.Rows("t")); got != `1="a" 2="b" ` {
+		t.Fatalf("rows were imported from the changed file: %s", got)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			"multi-line records",
+			"ts,data\n1,\"line one\nline two\"\n2,\"say \"\"hi\"\"\"\r\n3,\"a,b\"\n",
+			`1="line one\nline two" 2="say \"hi\"" 3="a,b" `,
+		},
+		{
+			"byte order mark and reordered header",
+			"\xef\xbb\xbfdata,ts\nx,1\ny,2\n",
+			`1="x" 2="y" `,
+		},
+		{
+			"no header",
+			"1,x\n\n2,y",
+			`1="x" 2="y" `,
+		},
+		{
+			"rfc3339",
+			"ts,data\n2024-01-02T03:04:05.000000006Z,x\n",
+			fmt.Sprintf(`%d="x" `, time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC).UnixNano()),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			path := writeFile(t, "rows.csv", tc.content)
+			if _, err := File(context.Background(), client, "t", path, WithEncoding(export.UTF8)); err != nil {
+				t.Fatal(err)
+			}
+			if got := rowsString(srv.Rows("t")); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInvalidLines(t *testing.T) {
+	content := "ts,data\n1,YQ==\n2,not base64\nyesterday,Yg==\n4,Yw==\n,ZA==\n"
+
+	srv, client := dbsdktest.Start(t)
+	path := writeFile(t, "rows.csv", content)
+	_, err := File(context.Background(), client, "t", path, WithCheckpoint(""))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 {
+		t.Fatalf("expected a parse error on line 3, got %v", err)
+	}
+	if len(srv.Rows("t")) != 0 {
+		t.Fatalf("rows of a failed batch were imported")
+	}
+
+	var lines []int64
+	p, err := File(context.Background(), client, "t", path, WithCheckpoint(""), WithSkipInvalid(func(err *ParseError) {
+		lines = append(lines, err.Line)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rows != 2 || p.Skipped != 3 || fmt.Sprint(lines) != "[3 4 6]" {
+		t.Fatalf("imported %d rows and skipped %d on lines %v", p.Rows, p.Skipped, lines)
+	}
+	if got := rowsString(srv.Rows("t")); got != `1="a" 4="c" ` {
+		t.Fatalf("got %s", got)
+	}
+
+	// rows outside the time range are invalid too
+	p, err = File(context.Background(), client, "u", path, WithCheckpoint(""), WithSkipInvalid(nil), WithTimeRange(time.Unix(0, 2), time.Time{}))
+	if err != nil || p.Rows != 1 || p.Skipped != 4 {
+		t.Fatalf("imported %d rows and skipped %d: %v", p.Rows, p.Skipped, err)
+	}
+}
+
+func TestReimport(t *testing.T) {
+	const first, second = "ts,data\n1,YQ==\n2,Yg==\n3,Yw==\n", "ts,data\n7,YQ==\n8,Yg==\n9,Yw==\n"
+	tests := []struct {
+		name string
+		// between runs before the second import
+		between func(t *testing.T, srv *dbsdktest.Server, client *dbsdk.AppendDbSDKClient, path string)
+		want    string
+	}{
+		{
+			name: "changed file",
+			between: func(t *testing.T, _ *dbsdktest.Server, _ *dbsdk.AppendDbSDKClient, path string) {
+				if err := os.WriteFile(path, []byte(second), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: `1="a" 2="b" 3="c" 7="a" 8="b" 9="c" `,
+		},
+		{
+			name:    "same file",
+			between: func(*testing.T, *dbsdktest.Server, *dbsdk.AppendDbSDKClient, string) {},
+			want:    `1="a" 1="a" 2="b" 2="b" 3="c" 3="c" `,
+		},
+		{
+			name: "purged table",
+			between: func(t *testing.T, _ *dbsdktest.Server, client *dbsdk.AppendDbSDKClient, _ string) {
+				if err := client.Purge("t"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: `1="a" 2="b" 3="c" `,
+		},
+		{
+			name: "restarted after a change",
+			between: func(t *testing.T, srv *dbsdktest.Server, client *dbsdk.AppendDbSDKClient, path string) {
+				// a partial import of the new content fails, the file changes again and
+				// the import is started over without its checkpoint
+				if err := os.WriteFile(path, []byte(second), 0644); err != nil {
+					t.Fatal(err)
+				}
+				fail := WithProgress(0, func(Progress) { srv.Fail("BatchAppend", errors.New("down")) })
+				if _, err := File(context.Background(), client, "t", path, WithBatchSize(2), fail); err == nil {
+					t.Fatal("expected the import to fail")
+				}
+				srv.Reset()
+				if err := os.WriteFile(path, []byte("ts,data\n7,eA==\n8,eQ==\n9,eg==\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Remove(path + ".checkpoint"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: `1="a" 2="b" 3="c" 7="a" 7="x" 8="b" 8="y" 9="z" `,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			path := writeFile(t, "rows.csv", first)
+			if _, err := File(context.Background(), client, "t", path, WithBatchSize(2)); err != nil {
+				t.Fatalf("first import: %v", err)
+			}
+
+			tt.between(t, srv, client, path)
+			p, err := File(context.Background(), client, "t", path, WithBatchSize(2))
+			if err != nil {
+				t.Fatalf("second import: %v", err)
+			}
+			if p.Rows != 3 {
+				t.Fatalf("second import reports %d rows", p.Rows)
+			}
+			if got := rowsString(srv.Rows("t")); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
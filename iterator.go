@@ -0,0 +1,85 @@
+package dbsdk
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// RowIterator walks the rows of a Scan, holding at most one chunk of rows in memory
+//
+//	it := client.Scan(ctx, table, start, stop)
+//	defer it.Close()
+//	for it.Next() {
+//		row := it.Row()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type RowIterator struct {
+	table  string
+	stream proto.DBService_StreamQueryClient
+	cancel context.CancelFunc
+
+	chunk []*proto.DBTuple
+	pos   int
+	row   *proto.DBTuple
+	err   error
+	done  bool
+}
+
+// Scan streams the rows for a table that are between start and stop inclusive
+// The scan lives as long as ctx, the client's request timeout does not apply
+func (s *AppendDbSDKClient) Scan(ctx context.Context, table string, start, stop time.Time) *RowIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &RowIterator{table: table, cancel: cancel}
+
+	req := proto.QueryRequest{Table: table, Start: start.UnixNano(), Stop: stop.UnixNano(), ChunkSize: int32(s.scanChunkSize)}
+	it.stream, it.err = s.stub.StreamQuery(ctx, &req)
+	if it.err != nil {
+		it.err = wrapErr("StreamQuery", table, it.err)
+		it.Close()
+	}
+	return it
+}
+
+// Next advances to the next row, it returns false when the scan is finished or failed
+func (it *RowIterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	for it.pos >= len(it.chunk) {
+		resp, err := it.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				it.err = wrapErr("StreamQuery", it.table, err)
+			}
+			it.Close()
+			return false
+		}
+		it.chunk, it.pos = resp.Data, 0
+	}
+
+	it.row = it.chunk[it.pos]
+	it.pos++
+	return true
+}
+
+// Row returns the current row
+func (it *RowIterator) Row() *proto.DBTuple {
+	return it.row
+}
+
+// Err returns the error that stopped the scan, if any
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// Close stops the scan early and releases its stream
+func (it *RowIterator) Close() {
+	it.done = true
+	it.chunk, it.row = nil, nil
+	it.cancel()
+}
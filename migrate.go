@@ -0,0 +1,517 @@
+package dbsdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// MigrateOption configures Migrate
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	start, stop time.Time
+	rename      string
+	workers     int
+	chunk       time.Duration
+	rate        float64
+	batchSize   int
+	runID       string
+	verify      bool
+	progress    func(MigrateProgress)
+	// nilResume is set by WithResume(nil), which Migrate rejects
+	nilResume bool
+}
+
+func defaultMigrateOptions() migrateOptions {
+	return migrateOptions{
+		start:     time.Unix(0, math.MinInt64),
+		stop:      time.Unix(0, math.MaxInt64),
+		workers:   4,
+		batchSize: 1000,
+		verify:    true,
+	}
+}
+
+// WithMigrateRange copies only the rows between start and stop inclusive, the default is every row
+func WithMigrateRange(start, stop time.Time) MigrateOption {
+	return func(o *migrateOptions) {
+		o.start, o.stop = start, stop
+	}
+}
+
+// WithRename writes the rows to a table of another name on the destination
+func WithRename(table string) MigrateOption {
+	return func(o *migrateOptions) {
+		o.rename = table
+	}
+}
+
+// WithWorkers sets how many chunks are copied at the same time, the default is 4
+func WithWorkers(n int) MigrateOption {
+	return func(o *migrateOptions) {
+		o.workers = n
+	}
+}
+
+// WithChunkDuration sets the span of time a worker copies in one go
+// The default splits the range into 8 chunks per worker
+func WithChunkDuration(d time.Duration) MigrateOption {
+	return func(o *migrateOptions) {
+		o.chunk = d
+	}
+}
+
+// WithRateLimit caps the rows written to the destination per second across all workers
+func WithRateLimit(rowsPerSecond float64) MigrateOption {
+	return func(o *migrateOptions) {
+		o.rate = rowsPerSecond
+	}
+}
+
+// WithMigrateBatchSize sets the most rows sent in one append, the default is 1000
+func WithMigrateBatchSize(n int) MigrateOption {
+	return func(o *migrateOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithRunID sets the identifier of the migration that goes into the idempotency keys of
+// its batches, the default is a new random identifier
+// The keys also depend on the chunks, use WithResume to run a failed migration again
+func WithRunID(id string) MigrateOption {
+	return func(o *migrateOptions) {
+		o.runID = id
+	}
+}
+
+// WithResume runs a failed migration again with its run identifier, range and chunk
+// duration, so its chunks and batch keys are the same even if the source was written
+// to since, and the batches the destination still remembers are not duplicated
+// Rows written inside the range in between change the batches they fall in, which
+// the destination rejects rather than taking them for retries
+func WithResume(prev *MigrateResult) MigrateOption {
+	return func(o *migrateOptions) {
+		if prev == nil {
+			o.nilResume = true
+			return
+		}
+		o.runID = prev.RunID
+		if prev.ChunkDuration > 0 {
+			o.start, o.stop, o.chunk = prev.Start, prev.Stop, prev.ChunkDuration
+		}
+	}
+}
+
+// WithVerify turns the verification pass on or off, it is on by default
+func WithVerify(verify bool) MigrateOption {
+	return func(o *migrateOptions) {
+		o.verify = verify
+	}
+}
+
+// WithMigrateProgress is called every time a chunk is copied
+func WithMigrateProgress(fn func(MigrateProgress)) MigrateOption {
+	return func(o *migrateOptions) {
+		o.progress = fn
+	}
+}
+
+// MigrateProgress describes how far a migration got
+type MigrateProgress struct {
+	Chunks     int
+	ChunksDone int
+	Rows       int64
+}
+
+// MigrateResult describes a finished migration
+type MigrateResult struct {
+	// RunID identifies the migration, see WithRunID
+	RunID string
+	// Start, Stop and ChunkDuration are the range the rows were copied from once
+	// limited to the rows of the source, and the span of its chunks, see WithResume
+	Start, Stop   time.Time
+	ChunkDuration time.Duration
+	Rows          int64
+	Chunks        int
+	Verified      bool
+}
+
+// ChunkMismatch is a chunk whose rows differ between the servers
+type ChunkMismatch struct {
+	Start, Stop time.Time
+	SrcRows     int64
+	DstRows     int64
+}
+
+// VerifyError is returned by Migrate when the destination doesn't hold the rows that were copied
+type VerifyError struct {
+	Table string
+	// SrcStats and DstStats are set when the stats of a whole table migration differ
+	SrcStats, DstStats *proto.TableStatTuple
+	Chunks             []ChunkMismatch
+}
+
+func (e *VerifyError) Error() string {
+	if len(e.Chunks) > 0 {
+		c := e.Chunks[0]
+		return fmt.Sprintf("verify %s: %d chunks differ, first from %s to %s has %d rows on the source and %d on the destination",
+			e.Table, len(e.Chunks), c.Start.UTC().Format(time.RFC3339Nano), c.Stop.UTC().Format(time.RFC3339Nano), c.SrcRows, c.DstRows)
+	}
+	return fmt.Sprintf("verify %s: the source has %d rows from %d to %d, the destination %d rows from %d to %d", e.Table,
+		e.SrcStats.RowCount, e.SrcStats.OldestTS, e.SrcStats.NewestTS, e.DstStats.RowCount, e.DstStats.OldestTS, e.DstStats.NewestTS)
+}
+
+// migrateChunk is a time range copied by one worker, with the hash of the source rows
+type migrateChunk struct {
+	start, stop int64
+	rows        int64
+	sum         []byte
+}
+
+// Migrate will copy the rows of table from src to dst
+// The range is split into chunks of time that are copied in parallel, each chunk in
+// order, so rows with the same timestamp keep their order. Every batch carries an
+// idempotency key made from the source, the run identifier and its place in the range,
+// so running a failed migration again with WithResume(result) does not duplicate the
+// batches the destination still remembers
+// The verification pass hashes every chunk on the destination and compares it with
+// the rows read from the source, and for a whole table also compares Stats, so the
+// range should not be written to while it runs
+// Copying a table onto itself is rejected when src and dst dial the same target,
+// use WithRename to copy it to another table of the same server
+func Migrate(ctx context.Context, src, dst *AppendDbSDKClient, table string, opts ...MigrateOption) (*MigrateResult, error) {
+	o := defaultMigrateOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.nilResume {
+		return nil, errors.New("migrate: WithResume needs the result of a previous migration")
+	}
+	if o.workers <= 0 || o.batchSize <= 0 {
+		return nil, errors.New("migrate: workers and batch size must be positive")
+	}
+	if o.chunk < 0 || o.rate < 0 {
+		return nil, errors.New("migrate: chunk duration and rate limit must not be negative")
+	}
+	to := table
+	if o.rename != "" {
+		to = o.rename
+	}
+	if to == table && (src == dst || src.conn.Target() == dst.conn.Target()) {
+		return nil, errors.New("migrate: the source and destination are the same table, use WithRename")
+	}
+	if o.runID == "" {
+		o.runID = newIdempotencyKey()
+	}
+	result := &MigrateResult{RunID: o.runID}
+	scope := strings.Join([]string{src.conn.Target(), table, to, o.runID}, "\x00")
+
+	stats, err := src.StatsContext(ctx, table)
+	if err != nil {
+		return result, err
+	}
+	start, stop := o.start.UnixNano(), o.stop.UnixNano()
+	if stats.OldestTS > start {
+		start = stats.OldestTS
+	}
+	if stats.NewestTS < stop {
+		stop = stats.NewestTS
+	}
+	if stats.RowCount == 0 || start > stop {
+		if !o.verify {
+			return result, nil
+		}
+		// nothing is copied, the destination must not hold rows in the range either
+		rows, _, err := hashRange(ctx, dst, to, o.start.UnixNano(), o.stop.UnixNano())
+		if err != nil {
+			return result, err
+		}
+		if rows > 0 {
+			return result, &VerifyError{Table: to, Chunks: []ChunkMismatch{{Start: o.start, Stop: o.stop, DstRows: rows}}}
+		}
+		result.Verified = true
+		return result, nil
+	}
+
+	chunks, size := splitRange(start, stop, o.chunk, o.workers*8)
+	result.Start, result.Stop, result.ChunkDuration = time.Unix(0, start), time.Unix(0, stop), time.Duration(size)
+	result.Chunks = len(chunks)
+
+	var limiter *tokenBucket
+	if o.rate > 0 {
+		limiter = newTokenBucket(o.rate, o.batchSize)
+	}
+
+	var (
+		mu   sync.Mutex
+		done int
+	)
+	err = parallel(ctx, o.workers, len(chunks), func(ctx context.Context, i int) error {
+		if err := copyChunk(ctx, src, dst, table, to, scope, &chunks[i], o.batchSize, limiter); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		result.Rows += chunks[i].rows
+		if o.progress != nil {
+			o.progress(MigrateProgress{Chunks: len(chunks), ChunksDone: done, Rows: result.Rows})
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if !o.verify {
+		return result, nil
+	}
+
+	verr := &VerifyError{Table: to}
+	err = parallel(ctx, o.workers, len(chunks), func(ctx context.Context, i int) error {
+		c := &chunks[i]
+		rows, sum, err := hashRange(ctx, dst, to, c.start, c.stop)
+		if err != nil {
+			return err
+		}
+		if rows != c.rows || !bytes.Equal(sum, c.sum) {
+			mu.Lock()
+			verr.Chunks = append(verr.Chunks, ChunkMismatch{Start: time.Unix(0, c.start), Stop: time.Unix(0, c.stop), SrcRows: c.rows, DstRows: rows})
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// the stats only describe the same rows when the whole table was copied
+	if o.start.UnixNano() <= stats.OldestTS && o.stop.UnixNano() >= stats.NewestTS {
+		dstStats, err := dst.StatsContext(ctx, to)
+		if err != nil {
+			return result, err
+		}
+		if dstStats.RowCount != stats.RowCount || dstStats.OldestTS != stats.OldestTS || dstStats.NewestTS != stats.NewestTS {
+			verr.SrcStats, verr.DstStats = stats, dstStats
+		}
+	}
+
+	if len(verr.Chunks) > 0 || verr.SrcStats != nil {
+		sort.Slice(verr.Chunks, func(i, j int) bool { return verr.Chunks[i].Start.Before(verr.Chunks[j].Start) })
+		return result, verr
+	}
+	result.Verified = true
+	return result, nil
+}
+
+// splitRange cuts start to stop inclusive into chunks of d, or into about n chunks when d is 0
+// It returns the chunks and their span
+func splitRange(start, stop int64, d time.Duration, n int) ([]migrateChunk, uint64) {
+	span := uint64(stop) - uint64(start) + 1
+	size := uint64(d)
+	if size == 0 {
+		size = span / uint64(n)
+		if span%uint64(n) != 0 {
+			size++
+		}
+	}
+	if size == 0 {
+		size = 1
+	}
+
+	var chunks []migrateChunk
+	for off := uint64(0); ; off += size {
+		c := migrateChunk{start: start + int64(off)}
+		if span-off <= size {
+			c.stop = stop
+			return append(chunks, c), size
+		}
+		c.stop = c.start + int64(size) - 1
+		chunks = append(chunks, c)
+	}
+}
+
+// parallel runs fn for 0 to n-1 on workers goroutines and stops at the first error
+func parallel(ctx context.Context, workers, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	next := make(chan int)
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						first = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if first == nil && ctx.Err() != nil {
+		// canceled by the caller rather than by a failed chunk
+		return ctx.Err()
+	}
+	return first
+}
+
+func copyChunk(ctx context.Context, src, dst *AppendDbSDKClient, table, to, scope string, c *migrateChunk, batchSize int, limiter *tokenBucket) error {
+	it := src.Scan(ctx, table, time.Unix(0, c.start), time.Unix(0, c.stop))
+	defer it.Close()
+
+	h := sha256.New()
+	c.rows = 0
+	rows := make([]Row, 0, batchSize)
+	size, batch := 0, 0
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		if limiter != nil {
+			if err := limiter.wait(ctx, len(rows)); err != nil {
+				return err
+			}
+		}
+		key := migrateKey(scope, c.start, c.stop, batch)
+		if err := dst.AppendBatch(ContextWithIdempotencyKey(ctx, key), to, rows); err != nil {
+			return err
+		}
+		c.rows += int64(len(rows))
+		rows, size = rows[:0], 0
+		batch++
+		return nil
+	}
+
+	for it.Next() {
+		row := it.Row()
+		hashRow(h, row)
+		rows = append(rows, Row{Ts: time.Unix(0, row.Ts), Data: row.Data})
+		size += len(row.Data)
+		if len(rows) >= batchSize || size >= 1<<20 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	c.sum = h.Sum(nil)
+	return nil
+}
+
+// hashRange counts and hashes the rows of a table between start and stop inclusive
+func hashRange(ctx context.Context, client *AppendDbSDKClient, table string, start, stop int64) (int64, []byte, error) {
+	it := client.Scan(ctx, table, time.Unix(0, start), time.Unix(0, stop))
+	defer it.Close()
+
+	h := sha256.New()
+	var rows int64
+	for it.Next() {
+		hashRow(h, it.Row())
+		rows++
+	}
+	if err := it.Err(); err != nil {
+		if errors.Is(err, ErrTableNotFound) {
+			return 0, h.Sum(nil), nil
+		}
+		return 0, nil, err
+	}
+	return rows, h.Sum(nil), nil
+}
+
+func hashRow(h hash.Hash, row *proto.DBTuple) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(row.Ts))
+	binary.BigEndian.PutUint64(b[8:], uint64(len(row.Data)))
+	h.Write(b[:])
+	h.Write(row.Data)
+}
+
+// migrateKey identifies a batch by the migration it belongs to and its place in the range
+// scope holds the source server and table, the destination table and the run identifier
+func migrateKey(scope string, start, stop int64, batch int) string {
+	h := sha256.New()
+	h.Write([]byte(scope))
+	var b [24]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(start))
+	binary.BigEndian.PutUint64(b[8:16], uint64(stop))
+	binary.BigEndian.PutUint64(b[16:], uint64(batch))
+	h.Write(b[:])
+	return "migrate-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// tokenBucket limits a rate shared by several goroutines, a caller may take more than
+// the burst at once and the bucket goes into debt that later callers wait out
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n tokens, blocking until the bucket has refilled enough or ctx is done
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
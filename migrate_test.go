@@ -0,0 +1,206 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fillTable appends n rows, three to a timestamp, starting at ts from
+func fillTable(t *testing.T, client *dbsdk.AppendDbSDKClient, table string, from int64, n int) {
+	t.Helper()
+	var rows []dbsdk.Row
+	for i := 0; i < n; i++ {
+		rows = append(rows, dbsdk.Row{Ts: time.Unix(0, from+int64(i/3)), Data: []byte(fmt.Sprintf("row %d", from+int64(i)))})
+	}
+	if err := client.AppendBatch(context.Background(), table, rows); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []dbsdk.MigrateOption
+		// the rows of the source that are copied
+		from, to int
+	}{
+		{name: "default", to: 300},
+		{name: "small chunks", opts: []dbsdk.MigrateOption{dbsdk.WithChunkDuration(7), dbsdk.WithMigrateBatchSize(4), dbsdk.WithWorkers(3)}, to: 300},
+		{name: "one worker", opts: []dbsdk.MigrateOption{dbsdk.WithWorkers(1), dbsdk.WithMigrateBatchSize(1)}, to: 300},
+		{name: "range", opts: []dbsdk.MigrateOption{dbsdk.WithMigrateRange(time.Unix(0, 1010), time.Unix(0, 1019))}, from: 30, to: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, srcClient := dbsdktest.Start(t)
+			dst, dstClient := dbsdktest.Start(t)
+			fillTable(t, srcClient, "t", 1000, 300)
+
+			opts := append([]dbsdk.MigrateOption{dbsdk.WithRename("copy")}, tt.opts...)
+			res, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", opts...)
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if !res.Verified || res.Rows != int64(tt.to-tt.from) || res.Chunks == 0 || res.RunID == "" {
+				t.Fatalf("result %+v", res)
+			}
+			sameRows(t, dst.Rows("copy"), src.Rows("t")[tt.from:tt.to])
+		})
+	}
+}
+
+func TestMigrateVerifyMismatch(t *testing.T) {
+	src, srcClient := dbsdktest.Start(t)
+	_, dstClient := dbsdktest.Start(t)
+	fillTable(t, srcClient, "t", 1000, 300)
+
+	// the destination already holds a row inside the range
+	if err := dstClient.Append("t", time.Unix(0, 1050), []byte("stray")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", dbsdk.WithChunkDuration(10))
+	var verr *dbsdk.VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got %v, want a VerifyError", err)
+	}
+	if res == nil || res.Verified || res.Rows != 300 {
+		t.Fatalf("result %+v", res)
+	}
+	if len(verr.Chunks) != 1 {
+		t.Fatalf("got %d mismatched chunks, want 1", len(verr.Chunks))
+	}
+	c := verr.Chunks[0]
+	if c.Start.UnixNano() > 1050 || c.Stop.UnixNano() < 1050 || c.SrcRows != 30 || c.DstRows != 31 {
+		t.Fatalf("mismatch %+v", c)
+	}
+	if verr.SrcStats == nil || verr.SrcStats.RowCount != int64(len(src.Rows("t"))) || verr.DstStats.RowCount != 301 {
+		t.Fatalf("stats %+v %+v", verr.SrcStats, verr.DstStats)
+	}
+
+	// a verified copy of a part of the table doesn't compare stats
+	if _, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", dbsdk.WithRename("part"),
+		dbsdk.WithMigrateRange(time.Unix(0, 1000), time.Unix(0, 1049))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	src, srcClient := dbsdktest.Start(t)
+	dst, dstClient := dbsdktest.Start(t)
+	fillTable(t, srcClient, "t", 1000, 300)
+
+	// the destination goes down after the first chunk
+	down := status.Error(codes.Unavailable, "down")
+	res, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", dbsdk.WithWorkers(1), dbsdk.WithMigrateBatchSize(7),
+		dbsdk.WithMigrateProgress(func(dbsdk.MigrateProgress) { dst.Fail("BatchAppend", down) }))
+	if !errors.Is(err, dbsdk.ErrUnavailable) {
+		t.Fatalf("got %v, want ErrUnavailable", err)
+	}
+	copied := len(dst.Rows("t"))
+	if copied == 0 || copied == 300 {
+		t.Fatalf("the first run copied %d rows", copied)
+	}
+	dst.Reset()
+
+	// the source keeps taking writes, which would move the chunks of a new run
+	fillTable(t, srcClient, "t", 2000, 30)
+
+	res, err = dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", dbsdk.WithWorkers(1), dbsdk.WithMigrateBatchSize(7), dbsdk.WithResume(res))
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if !res.Verified || res.Rows != 300 {
+		t.Fatalf("result %+v", res)
+	}
+	sameRows(t, dst.Rows("t"), src.Rows("t")[:300])
+}
+
+func TestMigrateOptions(t *testing.T) {
+	_, srcClient := dbsdktest.Start(t)
+	_, dstClient := dbsdktest.Start(t)
+	for _, opt := range []dbsdk.MigrateOption{dbsdk.WithWorkers(0), dbsdk.WithMigrateBatchSize(0), dbsdk.WithChunkDuration(-1), dbsdk.WithRateLimit(-1)} {
+		if _, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", opt); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+}
+
+func TestMigrateSameTable(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	other, err := srv.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	fillTable(t, client, "t", 1000, 30)
+
+	// a second client of the same server is the same table too
+	for _, dst := range []*dbsdk.AppendDbSDKClient{client, other} {
+		if _, err := dbsdk.Migrate(context.Background(), client, dst, "t"); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if n := len(srv.Rows("t")); n != 30 {
+		t.Fatalf("got %d rows, want the table untouched", n)
+	}
+
+	res, err := dbsdk.Migrate(context.Background(), client, other, "t", dbsdk.WithRename("copy"))
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if !res.Verified || res.Rows != 30 {
+		t.Fatalf("result %+v", res)
+	}
+	sameRows(t, srv.Rows("copy"), srv.Rows("t"))
+}
+
+func TestMigrateResumeNil(t *testing.T) {
+	_, srcClient := dbsdktest.Start(t)
+	_, dstClient := dbsdktest.Start(t)
+	if _, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", dbsdk.WithResume(nil)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMigrateEmptySource(t *testing.T) {
+	_, srcClient := dbsdktest.Start(t)
+	_, dstClient := dbsdktest.Start(t)
+	fillTable(t, srcClient, "t", 1000, 30)
+	empty := dbsdk.WithMigrateRange(time.Unix(0, 2000), time.Unix(0, 2999))
+
+	res, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", empty)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if !res.Verified || res.Rows != 0 {
+		t.Fatalf("result %+v", res)
+	}
+
+	// the destination holds a row in the range the source has none in
+	if err := dstClient.Append("t", time.Unix(0, 2500), []byte("stray")); err != nil {
+		t.Fatal(err)
+	}
+	res, err = dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", empty)
+	var verr *dbsdk.VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got %v, want a VerifyError", err)
+	}
+	if res == nil || res.Verified {
+		t.Fatalf("result %+v", res)
+	}
+	if len(verr.Chunks) != 1 || verr.Chunks[0].SrcRows != 0 || verr.Chunks[0].DstRows != 1 {
+		t.Fatalf("mismatch %+v", verr.Chunks)
+	}
+
+	if _, err := dbsdk.Migrate(context.Background(), srcClient, dstClient, "t", empty, dbsdk.WithVerify(false)); err != nil {
+		t.Fatalf("migrate without verify: %v", err)
+	}
+}
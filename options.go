@@ -0,0 +1,197 @@
+package dbsdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Option configures a client created with NewClient
+type Option func(*options)
+
+type options struct {
+	tlsConfig      *tls.Config
+	caFiles        []string
+	systemCertPool bool
+	clientCert     string
+	clientKey      string
+	serverName     string
+	insecure       bool
+	dialTimeout    time.Duration
+	requestTimeout time.Duration
+	scanChunkSize  int
+	nonBlocking    bool
+	retryPolicy    *RetryPolicy
+	dialOptions    []grpc.DialOption
+}
+
+func defaultOptions() options {
+	return options{
+		dialTimeout:    connectionTimeout,
+		requestTimeout: requestTimeout,
+		scanChunkSize:  scanChunkSize,
+	}
+}
+
+// WithTLSConfig sets the tls configuration used to connect to the server
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+// WithServerCertificate trusts the server's public certificate stored at path
+func WithServerCertificate(path string) Option {
+	return WithCABundle(path)
+}
+
+// WithCABundle trusts the PEM encoded certificate authorities stored at path
+// It can be given several times, the bundles are combined
+func WithCABundle(path string) Option {
+	return func(o *options) {
+		o.caFiles = append(o.caFiles, path)
+	}
+}
+
+// WithSystemCertPool trusts the certificate authorities of the host system
+// in addition to any given with WithCABundle
+func WithSystemCertPool() Option {
+	return func(o *options) {
+		o.systemCertPool = true
+	}
+}
+
+// WithClientCertificate presents the PEM encoded certificate and key pair to the server
+// for mutual tls
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.clientCert = certFile
+		o.clientKey = keyFile
+	}
+}
+
+// WithServerName overrides the name used to verify the server's certificate
+func WithServerName(name string) Option {
+	return func(o *options) {
+		o.serverName = name
+	}
+}
+
+// WithInsecure disables transport security entirely
+// Only use it for local development against a loopback server
+func WithInsecure() Option {
+	return func(o *options) {
+		o.insecure = true
+	}
+}
+
+// WithDialTimeout sets how long NewClient waits for the connection to be established
+// A zero or negative duration keeps the default
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d <= 0 {
+			d = connectionTimeout
+		}
+		o.dialTimeout = d
+	}
+}
+
+// WithRequestTimeout sets the timeout applied to every request
+// A zero or negative duration keeps the default
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d <= 0 {
+			d = requestTimeout
+		}
+		o.requestTimeout = d
+	}
+}
+
+// WithScanChunkSize sets how many rows Scan asks the server to send per message
+func WithScanChunkSize(n int) Option {
+	return func(o *options) {
+		o.scanChunkSize = n
+	}
+}
+
+// WithNonBlockingDial makes NewClient return immediately and connect in the background
+func WithNonBlockingDial() Option {
+	return func(o *options) {
+		o.nonBlocking = true
+	}
+}
+
+// WithRetryPolicy retries failed requests according to policy
+// See RetryInterceptor for which requests are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithDialOptions appends extra grpc dial options
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// transportCredentials builds the credentials used to dial the server
+// The certificate pool built from WithCABundle and WithSystemCertPool replaces
+// the RootCAs of a config given with WithTLSConfig
+func (o *options) transportCredentials() (credentials.TransportCredentials, error) {
+	if o.insecure {
+		if o.tlsConfig != nil || len(o.caFiles) > 0 || o.systemCertPool || o.clientCert != "" || o.serverName != "" {
+			return nil, errors.New("insecure mode can't be combined with tls options")
+		}
+		return insecure.NewCredentials(), nil
+	}
+
+	cfg := &tls.Config{}
+	if o.tlsConfig != nil {
+		cfg = o.tlsConfig.Clone()
+	}
+
+	if o.systemCertPool || len(o.caFiles) > 0 {
+		pool := x509.NewCertPool()
+		if o.systemCertPool {
+			sys, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, err
+			}
+			pool = sys
+		}
+
+		for _, path := range o.caFiles {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", path)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.clientCert, o.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	if o.serverName != "" {
+		cfg.ServerName = o.serverName
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
@@ -0,0 +1,256 @@
+package dbsdk_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const serverName = "db.test"
+
+// testCA issues certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// file is the CA certificate in a PEM file
+	file string
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(t.TempDir(), name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns a certificate signed by the CA and the PEM files holding it and its key
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (tls.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLS serves a memory server over tls on an in-process listener, clientCAs requires client certificates
+func startTLS(t *testing.T, ca *testCA, clientCAs *x509.CertPool) func(context.Context, string) (net.Conn, error) {
+	t.Helper()
+	cert, _, _ := ca.issue(t, serverName, x509.ExtKeyUsageServerAuth)
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = clientCAs
+	}
+
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(cfg)))
+	proto.RegisterDBServiceServer(srv, server.New(server.NewMemoryStorage()))
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+}
+
+// appendOver connects with opts and appends a row, the dial doesn't block so a failed handshake
+// is reported by the append
+func appendOver(t *testing.T, dialer func(context.Context, string) (net.Conn, error), opts ...dbsdk.Option) error {
+	t.Helper()
+	opts = append(opts, dbsdk.WithNonBlockingDial(), dbsdk.WithDialOptions(grpc.WithContextDialer(dialer)))
+	client, err := dbsdk.NewClient(context.Background(), "bufnet", opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client.AppendContext(ctx, "t", time.Unix(0, 1), []byte("row"))
+}
+
+func TestTLS(t *testing.T) {
+	ca := newCA(t, "ca")
+	other := newCA(t, "other")
+	dialer := startTLS(t, ca, nil)
+
+	t.Run("ca bundle", func(t *testing.T) {
+		if err := appendOver(t, dialer, dbsdk.WithCABundle(ca.file), dbsdk.WithServerName(serverName)); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendOver(t, dialer, dbsdk.WithCABundle(other.file), dbsdk.WithServerName(serverName)); err == nil {
+			t.Fatal("a server signed by an untrusted CA was accepted")
+		}
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		// the certificate is for serverName, not for the dialed target
+		if err := appendOver(t, dialer, dbsdk.WithCABundle(ca.file)); err == nil {
+			t.Fatal("a certificate for another name was accepted")
+		}
+	})
+
+	t.Run("ca bundle replaces the config roots", func(t *testing.T) {
+		roots := other.pool()
+		cfg := &tls.Config{RootCAs: roots, ServerName: serverName}
+		if err := appendOver(t, dialer, dbsdk.WithTLSConfig(cfg)); err == nil {
+			t.Fatal("the config's roots trusted the wrong CA")
+		}
+		if err := appendOver(t, dialer, dbsdk.WithTLSConfig(cfg), dbsdk.WithCABundle(ca.file)); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.RootCAs != roots {
+			t.Fatal("the caller's config was modified")
+		}
+	})
+
+	t.Run("tls config is cloned", func(t *testing.T) {
+		cfg := &tls.Config{RootCAs: ca.pool()}
+		if err := appendOver(t, dialer, dbsdk.WithTLSConfig(cfg), dbsdk.WithServerName(serverName)); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ServerName != "" {
+			t.Fatalf("the caller's config got ServerName %q", cfg.ServerName)
+		}
+	})
+
+	t.Run("system pool", func(t *testing.T) {
+		if _, err := x509.SystemCertPool(); err != nil {
+			t.Skipf("no system pool: %v", err)
+		}
+		if err := appendOver(t, dialer, dbsdk.WithSystemCertPool(), dbsdk.WithCABundle(ca.file), dbsdk.WithServerName(serverName)); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendOver(t, dialer, dbsdk.WithSystemCertPool(), dbsdk.WithServerName(serverName)); err == nil {
+			t.Fatal("the system pool trusted the test CA")
+		}
+	})
+
+	t.Run("pem without certificates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "empty.pem")
+		if err := os.WriteFile(path, []byte("not a certificate\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := appendOver(t, dialer, dbsdk.WithCABundle(path))
+		if err == nil || !strings.Contains(err.Error(), "no certificates found") {
+			t.Fatalf("got %v, want the bundle rejected", err)
+		}
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t, "ca")
+	clients := newCA(t, "clients")
+	dialer := startTLS(t, ca, clients.pool())
+	_, certFile, keyFile := clients.issue(t, "client", x509.ExtKeyUsageClientAuth)
+
+	if err := appendOver(t, dialer, dbsdk.WithCABundle(ca.file), dbsdk.WithServerName(serverName)); err == nil {
+		t.Fatal("the server accepted a client without a certificate")
+	}
+	if err := appendOver(t, dialer, dbsdk.WithCABundle(ca.file), dbsdk.WithServerName(serverName), dbsdk.WithClientCertificate(certFile, keyFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendOver(t, dialer, dbsdk.WithCABundle(ca.file), dbsdk.WithClientCertificate(certFile, filepath.Join(t.TempDir(), "missing.key"))); err == nil {
+		t.Fatal("a missing key file was accepted")
+	}
+}
+
+func TestInsecureWithTLSOptions(t *testing.T) {
+	ca := newCA(t, "ca")
+	_, certFile, keyFile := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+
+	tests := map[string]dbsdk.Option{
+		"tls config":  dbsdk.WithTLSConfig(&tls.Config{}),
+		"ca bundle":   dbsdk.WithCABundle(ca.file),
+		"server cert": dbsdk.WithServerCertificate(ca.file),
+		"system pool": dbsdk.WithSystemCertPool(),
+		"client cert": dbsdk.WithClientCertificate(certFile, keyFile),
+		"server name": dbsdk.WithServerName(serverName),
+	}
+	for name, opt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := dbsdk.NewClient(context.Background(), "bufnet", dbsdk.WithInsecure(), opt, dbsdk.WithNonBlockingDial())
+			if err == nil || !strings.Contains(err.Error(), "insecure") {
+				t.Fatalf("got %v, want insecure mode rejected", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package proto
+
+// Trailer keys set by AppendStream when it fails, they carry the count and the lastTs of the rows
+// stored before the error since a failed stream has no AppendStreamResponse
+const (
+	AppendStreamCountTrailer  = "append-stream-count"
+	AppendStreamLastTsTrailer = "append-stream-last-ts"
+)
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: service.proto
 
 package proto
 
@@ -21,8 +25,14 @@ type DBServiceClient interface {
 	Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*Empty, error)
 	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
 	Stats(ctx context.Context, in *TableRequest, opts ...grpc.CallOption) (*TableStatTuple, error)
-	ListTables(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListTablesResponse, error)
+	ListTables(ctx context.Context, in *ListTablesRequest, opts ...grpc.CallOption) (*ListTablesResponse, error)
 	Purge(ctx context.Context, in *TableRequest, opts ...grpc.CallOption) (*Empty, error)
+	BatchAppend(ctx context.Context, in *BatchAppendRequest, opts ...grpc.CallOption) (*BatchAppendResponse, error)
+	AppendStream(ctx context.Context, opts ...grpc.CallOption) (DBService_AppendStreamClient, error)
+	StreamQuery(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (DBService_StreamQueryClient, error)
+	// Subscribe replays the rows at or after fromTs in timestamp order
+	// then sends rows as they are appended
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DBService_SubscribeClient, error)
 }
 
 type dBServiceClient struct {
@@ -60,7 +70,7 @@ func (c *dBServiceClient) Stats(ctx context.Context, in *TableRequest, opts ...g
 	return out, nil
 }
 
-func (c *dBServiceClient) ListTables(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListTablesResponse, error) {
+func (c *dBServiceClient) ListTables(ctx context.Context, in *ListTablesRequest, opts ...grpc.CallOption) (*ListTablesResponse, error) {
 	out := new(ListTablesResponse)
 	err := c.cc.Invoke(ctx, "/proto.DBService/ListTables", in, out, opts...)
 	if err != nil {
@@ -78,6 +88,113 @@ func (c *dBServiceClient) Purge(ctx context.Context, in *TableRequest, opts ...g
 	return out, nil
 }
 
+func (c *dBServiceClient) BatchAppend(ctx context.Context, in *BatchAppendRequest, opts ...grpc.CallOption) (*BatchAppendResponse, error) {
+	out := new(BatchAppendResponse)
+	err := c.cc.Invoke(ctx, "/proto.DBService/BatchAppend", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dBServiceClient) AppendStream(ctx context.Context, opts ...grpc.CallOption) (DBService_AppendStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DBService_ServiceDesc.Streams[0], "/proto.DBService/AppendStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dBServiceAppendStreamClient{stream}
+	return x, nil
+}
+
+type DBService_AppendStreamClient interface {
+	Send(*AppendRequest) error
+	CloseAndRecv() (*AppendStreamResponse, error)
+	grpc.ClientStream
+}
+
+type dBServiceAppendStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *dBServiceAppendStreamClient) Send(m *AppendRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *dBServiceAppendStreamClient) CloseAndRecv() (*AppendStreamResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(AppendStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dBServiceClient) StreamQuery(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (DBService_StreamQueryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DBService_ServiceDesc.Streams[1], "/proto.DBService/StreamQuery", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dBServiceStreamQueryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DBService_StreamQueryClient interface {
+	Recv() (*QueryResponse, error)
+	grpc.ClientStream
+}
+
+type dBServiceStreamQueryClient struct {
+	grpc.ClientStream
+}
+
+func (x *dBServiceStreamQueryClient) Recv() (*QueryResponse, error) {
+	m := new(QueryResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dBServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DBService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DBService_ServiceDesc.Streams[2], "/proto.DBService/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dBServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DBService_SubscribeClient interface {
+	Recv() (*DBTuple, error)
+	grpc.ClientStream
+}
+
+type dBServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *dBServiceSubscribeClient) Recv() (*DBTuple, error) {
+	m := new(DBTuple)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // DBServiceServer is the server API for DBService service.
 // All implementations must embed UnimplementedDBServiceServer
 // for forward compatibility
@@ -85,8 +202,14 @@ type DBServiceServer interface {
 	Append(context.Context, *AppendRequest) (*Empty, error)
 	Query(context.Context, *QueryRequest) (*QueryResponse, error)
 	Stats(context.Context, *TableRequest) (*TableStatTuple, error)
-	ListTables(context.Context, *Empty) (*ListTablesResponse, error)
+	ListTables(context.Context, *ListTablesRequest) (*ListTablesResponse, error)
 	Purge(context.Context, *TableRequest) (*Empty, error)
+	BatchAppend(context.Context, *BatchAppendRequest) (*BatchAppendResponse, error)
+	AppendStream(DBService_AppendStreamServer) error
+	StreamQuery(*QueryRequest, DBService_StreamQueryServer) error
+	// Subscribe replays the rows at or after fromTs in timestamp order
+	// then sends rows as they are appended
+	Subscribe(*SubscribeRequest, DBService_SubscribeServer) error
 	mustEmbedUnimplementedDBServiceServer()
 }
 
@@ -103,12 +226,24 @@ func (UnimplementedDBServiceServer) Query(context.Context, *QueryRequest) (*Quer
 func (UnimplementedDBServiceServer) Stats(context.Context, *TableRequest) (*TableStatTuple, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
 }
-func (UnimplementedDBServiceServer) ListTables(context.Context, *Empty) (*ListTablesResponse, error) {
+func (UnimplementedDBServiceServer) ListTables(context.Context, *ListTablesRequest) (*ListTablesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListTables not implemented")
 }
 func (UnimplementedDBServiceServer) Purge(context.Context, *TableRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Purge not implemented")
 }
+func (UnimplementedDBServiceServer) BatchAppend(context.Context, *BatchAppendRequest) (*BatchAppendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchAppend not implemented")
+}
+func (UnimplementedDBServiceServer) AppendStream(DBService_AppendStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method AppendStream not implemented")
+}
+func (UnimplementedDBServiceServer) StreamQuery(*QueryRequest, DBService_StreamQueryServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamQuery not implemented")
+}
+func (UnimplementedDBServiceServer) Subscribe(*SubscribeRequest, DBService_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
 func (UnimplementedDBServiceServer) mustEmbedUnimplementedDBServiceServer() {}
 
 // UnsafeDBServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -177,7 +312,7 @@ func _DBService_Stats_Handler(srv interface{}, ctx context.Context, dec func(int
 }
 
 func _DBService_ListTables_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
+	in := new(ListTablesRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -189,7 +324,7 @@ func _DBService_ListTables_Handler(srv interface{}, ctx context.Context, dec fun
 		FullMethod: "/proto.DBService/ListTables",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(DBServiceServer).ListTables(ctx, req.(*Empty))
+		return srv.(DBServiceServer).ListTables(ctx, req.(*ListTablesRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -212,6 +347,92 @@ func _DBService_Purge_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DBService_BatchAppend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchAppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DBServiceServer).BatchAppend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.DBService/BatchAppend",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DBServiceServer).BatchAppend(ctx, req.(*BatchAppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DBService_AppendStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DBServiceServer).AppendStream(&dBServiceAppendStreamServer{stream})
+}
+
+type DBService_AppendStreamServer interface {
+	SendAndClose(*AppendStreamResponse) error
+	Recv() (*AppendRequest, error)
+	grpc.ServerStream
+}
+
+type dBServiceAppendStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *dBServiceAppendStreamServer) SendAndClose(m *AppendStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *dBServiceAppendStreamServer) Recv() (*AppendRequest, error) {
+	m := new(AppendRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _DBService_StreamQuery_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DBServiceServer).StreamQuery(m, &dBServiceStreamQueryServer{stream})
+}
+
+type DBService_StreamQueryServer interface {
+	Send(*QueryResponse) error
+	grpc.ServerStream
+}
+
+type dBServiceStreamQueryServer struct {
+	grpc.ServerStream
+}
+
+func (x *dBServiceStreamQueryServer) Send(m *QueryResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DBService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DBServiceServer).Subscribe(m, &dBServiceSubscribeServer{stream})
+}
+
+type DBService_SubscribeServer interface {
+	Send(*DBTuple) error
+	grpc.ServerStream
+}
+
+type dBServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *dBServiceSubscribeServer) Send(m *DBTuple) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // DBService_ServiceDesc is the grpc.ServiceDesc for DBService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -239,7 +460,27 @@ var DBService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Purge",
 			Handler:    _DBService_Purge_Handler,
 		},
+		{
+			MethodName: "BatchAppend",
+			Handler:    _DBService_BatchAppend_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AppendStream",
+			Handler:       _DBService_AppendStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "StreamQuery",
+			Handler:       _DBService_StreamQuery_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _DBService_Subscribe_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "service.proto",
 }
@@ -0,0 +1,156 @@
+package dbsdk
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after every retry
+	Multiplier float64
+	// Jitter randomises each wait by up to this fraction of it, 0.2 means +/-20%
+	Jitter float64
+	// RetryableCodes are the status codes worth retrying
+	RetryableCodes []codes.Code
+	// BudgetTokens and BudgetRatio throttle retries when the server keeps failing
+	// Every failure costs a token, every success earns BudgetRatio tokens back,
+	// and retries stop while fewer than half of BudgetTokens remain
+	// A zero BudgetTokens disables the budget
+	BudgetTokens float64
+	BudgetRatio  float64
+}
+
+// DefaultRetryPolicy returns a policy suitable for most clients
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.Aborted},
+		BudgetTokens:   10,
+		BudgetRatio:    0.1,
+	}
+}
+
+// idempotentMethods are always safe to retry
+var idempotentMethods = map[string]bool{
+	"/proto.DBService/Query":      true,
+	"/proto.DBService/Stats":      true,
+	"/proto.DBService/ListTables": true,
+}
+
+// idempotencyKeyed is implemented by requests that carry an idempotency key
+type idempotencyKeyed interface {
+	GetIdempotencyKey() string
+}
+
+// retryable reports whether the request for method can be sent more than once
+func retryable(method string, req interface{}) bool {
+	if idempotentMethods[method] {
+		return true
+	}
+	keyed, ok := req.(idempotencyKeyed)
+	return ok && keyed.GetIdempotencyKey() != ""
+}
+
+// retryBudget is a token bucket shared by all the requests of a client
+type retryBudget struct {
+	mu     sync.Mutex
+	max    float64
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// failure records a failed attempt and reports whether a retry is allowed
+func (b *retryBudget) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return b.tokens > b.max/2
+}
+
+// RetryInterceptor returns a unary interceptor that retries idempotent requests
+// Query, Stats and ListTables are always retried, appends only when they carry an idempotency key
+func RetryInterceptor(policy RetryPolicy) grpc.UnaryClientInterceptor {
+	codeSet := make(map[codes.Code]bool, len(policy.RetryableCodes))
+	for _, code := range policy.RetryableCodes {
+		codeSet[code] = true
+	}
+
+	var budget *retryBudget
+	if policy.BudgetTokens > 0 {
+		budget = &retryBudget{max: policy.BudgetTokens, ratio: policy.BudgetRatio, tokens: policy.BudgetTokens}
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !retryable(method, req) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		backoff := policy.InitialBackoff
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil {
+				if budget != nil {
+					budget.success()
+				}
+				return nil
+			}
+
+			if !codeSet[status.Code(err)] || attempt >= policy.MaxAttempts {
+				return err
+			}
+			if budget != nil && !budget.failure() {
+				return err
+			}
+
+			timer := time.NewTimer(jitter(backoff, policy.Jitter))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+			if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+}
+
+// jitter spreads d randomly by up to frac of it in either direction
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + frac*(2*rand.Float64()-1)))
+}
@@ -0,0 +1,189 @@
+package dbsdk_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryConn connects a plain client to a fresh server through the retry interceptor,
+// so requests can be sent with and without idempotency keys
+func retryConn(t *testing.T, policy dbsdk.RetryPolicy) (*dbsdktest.Server, proto.DBServiceClient) {
+	t.Helper()
+	srv := dbsdktest.NewServer()
+	t.Cleanup(srv.Close)
+
+	conn, err := srv.Conn(context.Background(), grpc.WithUnaryInterceptor(dbsdk.RetryInterceptor(policy)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, proto.NewDBServiceClient(conn)
+}
+
+func fastPolicy() dbsdk.RetryPolicy {
+	policy := dbsdk.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = time.Millisecond
+	policy.Jitter = 0
+	policy.BudgetTokens = 0
+	return policy
+}
+
+func TestRetryCodes(t *testing.T) {
+	tests := []struct {
+		code  codes.Code
+		calls int
+	}{
+		{codes.Unavailable, 2},
+		{codes.ResourceExhausted, 2},
+		{codes.Aborted, 2},
+		{codes.InvalidArgument, 1},
+		{codes.NotFound, 1},
+		{codes.Internal, 1},
+		{codes.DeadlineExceeded, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			srv, client := retryConn(t, fastPolicy())
+			srv.FailNext("ListTables", 1, status.Error(tt.code, "injected"))
+
+			_, err := client.ListTables(context.Background(), &proto.ListTablesRequest{})
+			if tt.calls > 1 && err != nil {
+				t.Fatalf("got %v, want the retry to succeed", err)
+			}
+			if tt.calls == 1 && status.Code(err) != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+			if n := srv.Calls("ListTables"); n != tt.calls {
+				t.Fatalf("got %d calls, want %d", n, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryAppends(t *testing.T) {
+	row := &proto.DBTuple{Ts: 1, Data: []byte("row")}
+	appends := map[string]func(proto.DBServiceClient, string) error{
+		"Append": func(client proto.DBServiceClient, key string) error {
+			_, err := client.Append(context.Background(), &proto.AppendRequest{Table: "t", Data: row, IdempotencyKey: key})
+			return err
+		},
+		"BatchAppend": func(client proto.DBServiceClient, key string) error {
+			_, err := client.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: "t", Data: []*proto.DBTuple{row}, IdempotencyKey: key})
+			return err
+		},
+	}
+	for method, send := range appends {
+		for _, key := range []string{"", "k"} {
+			t.Run(method+"/key="+key, func(t *testing.T) {
+				srv, client := retryConn(t, fastPolicy())
+				srv.FailNext(method, 1, status.Error(codes.Unavailable, "injected"))
+
+				err := send(client, key)
+				want, rows := 2, 1
+				if key == "" {
+					// the first attempt may have been applied, only a key makes it safe to send again
+					want, rows = 1, 0
+					if status.Code(err) != codes.Unavailable {
+						t.Fatalf("got %v, want Unavailable", err)
+					}
+				} else if err != nil {
+					t.Fatalf("append: %v", err)
+				}
+				if n := srv.Calls(method); n != want {
+					t.Fatalf("got %d calls, want %d", n, want)
+				}
+				if n := len(srv.Rows("t")); n != rows {
+					t.Fatalf("got %d rows, want %d", n, rows)
+				}
+			})
+		}
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	policy := fastPolicy()
+	policy.MaxAttempts = 3
+	srv, client := retryConn(t, policy)
+	srv.Fail("Stats", status.Error(codes.Unavailable, "injected"))
+
+	_, err := client.Stats(context.Background(), &proto.TableRequest{Table: "t"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want Unavailable", err)
+	}
+	if n := srv.Calls("Stats"); n != 3 {
+		t.Fatalf("got %d calls, want 3", n)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	policy := fastPolicy()
+	policy.MaxAttempts = 10
+	policy.BudgetTokens = 4
+	policy.BudgetRatio = 1
+	srv, client := retryConn(t, policy)
+	srv.Fail("Stats", status.Error(codes.Unavailable, "injected"))
+
+	// retries stop once half of the tokens are spent: 4 -> 3 allows one retry, 3 -> 2 doesn't
+	_, err := client.Stats(context.Background(), &proto.TableRequest{Table: "t"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want Unavailable", err)
+	}
+	if n := srv.Calls("Stats"); n != 2 {
+		t.Fatalf("got %d calls, want 2", n)
+	}
+
+	// with the budget spent a failure is not retried at all
+	client.Stats(context.Background(), &proto.TableRequest{Table: "t"})
+	if n := srv.Calls("Stats"); n != 3 {
+		t.Fatalf("got %d calls, want 3", n)
+	}
+
+	// successes earn the tokens back
+	srv.Reset()
+	for i := 0; i < 3; i++ {
+		if _, err := client.ListTables(context.Background(), &proto.ListTablesRequest{}); err != nil {
+			t.Fatalf("list: %v", err)
+		}
+	}
+	srv.FailNext("Stats", 1, status.Error(codes.Unavailable, "injected"))
+	if _, err := client.Stats(context.Background(), &proto.TableRequest{Table: "t"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want the retry to reach the server", err)
+	}
+}
+
+func TestRetryCancelDuringBackoff(t *testing.T) {
+	policy := fastPolicy()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	srv, client := retryConn(t, policy)
+	srv.Fail("Stats", status.Error(codes.Unavailable, "injected"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Stats(ctx, &proto.TableRequest{Table: "t"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("got %v, want the last error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retry kept waiting after ctx was cancelled")
+	}
+	if n := srv.Calls("Stats"); n != 1 {
+		t.Fatalf("got %d calls, want 1", n)
+	}
+}
@@ -2,97 +2,315 @@ package dbsdk
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"github.com/r-coffee/db-append-only-sdk/proto"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	connectionTimeout = 5 * time.Second
 	requestTimeout    = 3 * time.Second
+	scanChunkSize     = 1000
 )
 
 type AppendDbSDKClient struct {
-	stub proto.DBServiceClient
+	conn           *grpc.ClientConn
+	stub           proto.DBServiceClient
+	requestTimeout time.Duration
+	scanChunkSize  int
 }
 
 // CreateAppendDBClient creates a new sdk client
 // host is the hostname of the server
 // pathToCert is the path to the server's public certificate
 // port is the port number the server service is running on
+//
+// Deprecated: CreateAppendDBClient exits the process on failure, use NewClient instead
 func CreateAppendDBClient(host, pathToCert string, port int) *AppendDbSDKClient {
-	var sdk AppendDbSDKClient
-	creds, err := credentials.NewClientTLSFromFile(pathToCert, host)
+	sdk, err := NewClient(context.Background(), fmt.Sprintf("%s:%d", host, port), WithServerCertificate(pathToCert))
 	if err != nil {
 		log.Fatal(err)
 	}
+	return sdk
+}
 
-	// connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), connectionTimeout)
-	defer cancel()
+// NewClient creates a new sdk client connected to target (host:port)
+// Unless WithNonBlockingDial is given it waits for the connection to be established,
+// bounded by ctx and the dial timeout
+func NewClient(ctx context.Context, target string, opts ...Option) (*AppendDbSDKClient, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-	conn, err := grpc.DialContext(ctx, fmt.Sprintf("%s:%d", host, port), grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	creds, err := o.transportCredentials()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	sdk.stub = proto.NewDBServiceClient(conn)
-	return &sdk
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if o.retryPolicy != nil {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(RetryInterceptor(*o.retryPolicy)))
+	}
+	if !o.nonBlocking {
+		dialOpts = append(dialOpts, grpc.WithBlock())
+
+		// connection timeout
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.dialTimeout)
+		defer cancel()
+	}
+	dialOpts = append(dialOpts, o.dialOptions...)
+
+	conn, err := grpc.DialContext(ctx, target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppendDbSDKClient{
+		conn:           conn,
+		stub:           proto.NewDBServiceClient(conn),
+		requestTimeout: o.requestTimeout,
+		scanChunkSize:  o.scanChunkSize,
+	}, nil
+}
+
+// Close tears down the connection to the server
+func (s *AppendDbSDKClient) Close() error {
+	return s.conn.Close()
+}
+
+// State returns the connectivity state of the connection to the server
+func (s *AppendDbSDKClient) State() connectivity.State {
+	return s.conn.GetState()
+}
+
+// WaitForReady blocks until the connection is ready or ctx is done
+func (s *AppendDbSDKClient) WaitForReady(ctx context.Context) error {
+	for {
+		state := s.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return errors.New("connection is closed")
+		}
+
+		if !s.conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// withTimeout applies the client's request timeout when ctx has no deadline of its own
+func (s *AppendDbSDKClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.requestTimeout)
 }
 
 // Append will write a new row to the table
 func (s *AppendDbSDKClient) Append(table string, ts time.Time, dat []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return s.AppendContext(context.Background(), table, ts, dat)
+}
+
+// AppendContext is like Append but uses ctx for the request
+// The request carries an idempotency key so retries never write the row twice,
+// see ContextWithIdempotencyKey to supply your own
+func (s *AppendDbSDKClient) AppendContext(ctx context.Context, table string, ts time.Time, dat []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
 	defer cancel()
 
 	var tup proto.DBTuple
 	tup.Ts = ts.UnixNano()
 	tup.Data = dat
 
-	_, err := s.stub.Append(ctx, &proto.AppendRequest{Table: table, Data: &tup})
-	return err
+	_, err := s.stub.Append(ctx, &proto.AppendRequest{Table: table, Data: &tup, IdempotencyKey: idempotencyKey(ctx)})
+	return wrapErr("Append", table, err)
 }
 
 // Query will return all the rows for a table that are between start and stop inclusive
 func (s *AppendDbSDKClient) Query(table string, start, stop time.Time) ([]*proto.DBTuple, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return s.QueryContext(context.Background(), table, start, stop)
+}
+
+// QueryContext is like Query but uses ctx for the request
+func (s *AppendDbSDKClient) QueryContext(ctx context.Context, table string, start, stop time.Time) ([]*proto.DBTuple, error) {
+	ctx, cancel := s.withTimeout(ctx)
 	defer cancel()
 
 	resp, err := s.stub.Query(ctx, &proto.QueryRequest{Table: table, Start: start.UnixNano(), Stop: stop.UnixNano()})
 
 	// handle nil response
 	if resp == nil {
-		return nil, err
+		return nil, wrapErr("Query", table, err)
 	}
 
-	return resp.Data, err
+	return resp.Data, wrapErr("Query", table, err)
+}
+
+// QueryPage returns up to limit rows for a table that are between start and stop inclusive
+// Pass an empty token for the first page and the returned token for the next ones,
+// an empty next token means there are no more rows
+// limit must be positive, use Scan to read a whole range
+func (s *AppendDbSDKClient) QueryPage(ctx context.Context, table string, start, stop time.Time, limit int, token string) ([]*proto.DBTuple, string, error) {
+	if limit <= 0 {
+		return nil, "", wrapErr("Query", table, status.Errorf(codes.InvalidArgument, "limit %d is not positive", limit))
+	}
+	if limit > math.MaxInt32 {
+		return nil, "", wrapErr("Query", table, status.Errorf(codes.InvalidArgument, "limit %d is larger than %d", limit, math.MaxInt32))
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := s.stub.Query(ctx, &proto.QueryRequest{
+		Table:     table,
+		Start:     start.UnixNano(),
+		Stop:      stop.UnixNano(),
+		Limit:     int32(limit),
+		PageToken: token,
+	})
+	if err != nil {
+		return nil, "", wrapErr("Query", table, err)
+	}
+
+	return resp.Data, resp.NextPageToken, nil
+}
+
+// Latest returns the newest n rows of a table, newest first
+// n <= 0 returns no rows, a limit of 0 would mean every row to the server
+// A server that ignores the limit and order of queries sends the whole table, which is
+// then reordered and trimmed here
+func (s *AppendDbSDKClient) Latest(ctx context.Context, table string, n int) ([]*proto.DBTuple, error) {
+	return s.edge(ctx, table, n, true)
+}
+
+// Earliest returns the oldest n rows of a table, oldest first, n <= 0 returns no rows
+func (s *AppendDbSDKClient) Earliest(ctx context.Context, table string, n int) ([]*proto.DBTuple, error) {
+	return s.edge(ctx, table, n, false)
+}
+
+// edge queries the first n rows of the whole table in the given order
+func (s *AppendDbSDKClient) edge(ctx context.Context, table string, n int, descending bool) ([]*proto.DBTuple, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if n > math.MaxInt32 {
+		return nil, wrapErr("Query", table, status.Errorf(codes.InvalidArgument, "n %d is larger than %d", n, math.MaxInt32))
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := s.stub.Query(ctx, &proto.QueryRequest{
+		Table:      table,
+		Start:      math.MinInt64,
+		Stop:       math.MaxInt64,
+		Limit:      int32(n),
+		Descending: descending,
+	})
+	if err != nil {
+		return nil, wrapErr("Query", table, err)
+	}
+
+	// servers older than Limit and Descending return the whole table oldest first
+	rows := resp.Data
+	if descending && len(rows) > 1 && rows[0].Ts < rows[len(rows)-1].Ts {
+		if len(rows) == n && resp.NextPageToken != "" {
+			return nil, wrapErr("Query", table, status.Error(codes.Unimplemented, "the server ignores descending queries"))
+		}
+		reversed := make([]*proto.DBTuple, len(rows))
+		for i, row := range rows {
+			reversed[len(rows)-1-i] = row
+		}
+		rows = reversed
+	}
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+	return rows, nil
 }
 
 // Stats returns some statistics about the table
 func (s *AppendDbSDKClient) Stats(table string) (*proto.TableStatTuple, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return s.StatsContext(context.Background(), table)
+}
+
+// StatsContext is like Stats but uses ctx for the request
+func (s *AppendDbSDKClient) StatsContext(ctx context.Context, table string) (*proto.TableStatTuple, error) {
+	ctx, cancel := s.withTimeout(ctx)
 	defer cancel()
 
-	return s.stub.Stats(ctx, &proto.TableRequest{Table: table})
+	resp, err := s.stub.Stats(ctx, &proto.TableRequest{Table: table})
+	return resp, wrapErr("Stats", table, err)
 }
 
 // ListTables returns a list of all the tables in the server
 func (s *AppendDbSDKClient) ListTables() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return s.ListTablesContext(context.Background())
+}
+
+// ListTablesContext is like ListTables but uses ctx for the request
+func (s *AppendDbSDKClient) ListTablesContext(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var tables []string
+	token := ""
+	for {
+		resp, err := s.stub.ListTables(ctx, &proto.ListTablesRequest{PageToken: token})
+		if err != nil {
+			return nil, wrapErr("ListTables", "", err)
+		}
+
+		tables = append(tables, resp.Tables...)
+		if resp.NextPageToken == "" {
+			return tables, nil
+		}
+		token = resp.NextPageToken
+	}
+}
+
+// ListTablesPage returns up to pageSize tables whose name starts with prefix, in name order
+// A pageSize of 0 lets the server choose, and the server may return fewer than asked for
+// Pass an empty token for the first page and the returned token for the next ones,
+// an empty next token means there are no more tables
+func (s *AppendDbSDKClient) ListTablesPage(ctx context.Context, prefix string, pageSize int, token string) ([]string, string, error) {
+	if pageSize > math.MaxInt32 {
+		return nil, "", wrapErr("ListTables", "", status.Errorf(codes.InvalidArgument, "page size %d is larger than %d", pageSize, math.MaxInt32))
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
 	defer cancel()
 
-	resp, err := s.stub.ListTables(ctx, &proto.Empty{})
-	return resp.Tables, err
+	resp, err := s.stub.ListTables(ctx, &proto.ListTablesRequest{Prefix: prefix, PageSize: int32(pageSize), PageToken: token})
+	if err != nil {
+		return nil, "", wrapErr("ListTables", "", err)
+	}
+
+	return resp.Tables, resp.NextPageToken, nil
 }
 
 // Purge removes a table and all of it's data from the server
 func (s *AppendDbSDKClient) Purge(table string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return s.PurgeContext(context.Background(), table)
+}
+
+// PurgeContext is like Purge but uses ctx for the request
+func (s *AppendDbSDKClient) PurgeContext(ctx context.Context, table string) error {
+	ctx, cancel := s.withTimeout(ctx)
 	defer cancel()
 
 	_, err := s.stub.Purge(ctx, &proto.TableRequest{Table: table})
-	return err
+	return wrapErr("Purge", table, err)
 }
@@ -0,0 +1,289 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestNonPositiveTimeouts(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		t.Run(d.String(), func(t *testing.T) {
+			srv, client := dbsdktest.Start(t, dbsdk.WithRequestTimeout(d), dbsdk.WithDialTimeout(d))
+			if err := client.Append("t", time.Unix(0, 1), []byte("row")); err != nil {
+				t.Fatalf("append: %v", err)
+			}
+			if n := len(srv.Rows("t")); n != 1 {
+				t.Fatalf("got %d rows, want 1", n)
+			}
+		})
+	}
+}
+
+func TestQueryPageTies(t *testing.T) {
+	_, client := dbsdktest.Start(t)
+	var rows []dbsdk.Row
+	var want []string
+	for i, ts := range []int64{1, 1, 2, 3, 3, 3, 3, 4, 5, 5} {
+		data := fmt.Sprintf("row %d", i)
+		rows = append(rows, dbsdk.Row{Ts: time.Unix(0, ts), Data: []byte(data)})
+		want = append(want, data)
+	}
+	if err := client.AppendBatch(context.Background(), "t", rows); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	for limit := 1; limit <= len(rows)+1; limit++ {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			var got []string
+			token := ""
+			for pages := 0; ; pages++ {
+				if pages > len(rows) {
+					t.Fatal("paging did not end")
+				}
+				page, next, err := client.QueryPage(context.Background(), "t", time.Unix(0, 1), time.Unix(0, 5), limit, token)
+				if err != nil {
+					t.Fatalf("query: %v", err)
+				}
+				if len(page) > limit {
+					t.Fatalf("page has %d rows", len(page))
+				}
+				for _, row := range page {
+					got = append(got, string(row.Data))
+				}
+				if next == "" {
+					break
+				}
+				token = next
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestQueryPageNonPositiveLimit(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	if err := client.Append("t", time.Unix(0, 1), []byte("row")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	for _, limit := range []int{0, -1} {
+		rows, next, err := client.QueryPage(context.Background(), "t", time.Unix(0, 0), time.Unix(0, 2), limit, "")
+		if !errors.Is(err, dbsdk.ErrInvalidArgument) || rows != nil || next != "" {
+			t.Errorf("QueryPage(%d): got %d rows, %q, %v, want ErrInvalidArgument", limit, len(rows), next, err)
+		}
+	}
+	if n := srv.Calls("Query"); n != 0 {
+		t.Fatalf("got %d queries, want the limit rejected by the client", n)
+	}
+}
+
+func TestLimitsPastInt32(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("int can't hold the limits")
+	}
+	_, client := dbsdktest.Start(t)
+	if err := client.Append("t", time.Unix(0, 1), []byte("row")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	for _, shift := range []uint{31, 32} {
+		n := 1 << shift
+		if _, _, err := client.ListTablesPage(context.Background(), "", n, ""); !errors.Is(err, dbsdk.ErrInvalidArgument) {
+			t.Errorf("ListTablesPage(1<<%d): got %v, want ErrInvalidArgument", shift, err)
+		}
+		if _, _, err := client.QueryPage(context.Background(), "t", time.Unix(0, 0), time.Unix(0, 2), n, ""); !errors.Is(err, dbsdk.ErrInvalidArgument) {
+			t.Errorf("QueryPage(1<<%d): got %v, want ErrInvalidArgument", shift, err)
+		}
+		if _, err := client.Latest(context.Background(), "t", n); !errors.Is(err, dbsdk.ErrInvalidArgument) {
+			t.Errorf("Latest(1<<%d): got %v, want ErrInvalidArgument", shift, err)
+		}
+	}
+}
+
+func TestListTablesPages(t *testing.T) {
+	const tables = 1001
+
+	srv, client := dbsdktest.Start(t)
+	for i := 0; i < tables; i++ {
+		if err := client.Append(fmt.Sprintf("t%04d", i), time.Unix(0, 1), []byte("row")); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	// the server's default page is smaller than the table count
+	got, err := client.ListTables()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !reflect.DeepEqual(got, srv.Tables()) {
+		t.Fatalf("listed %d tables, want %d", len(got), tables)
+	}
+	if n := srv.Calls("ListTables"); n != 2 {
+		t.Fatalf("got %d calls, want 2 pages", n)
+	}
+}
+
+// legacyServer answers queries like a server that predates Limit and Descending,
+// with every row in the range oldest first
+type legacyServer struct {
+	proto.UnimplementedDBServiceServer
+	rows []*proto.DBTuple
+}
+
+func (s *legacyServer) Query(ctx context.Context, req *proto.QueryRequest) (*proto.QueryResponse, error) {
+	var resp proto.QueryResponse
+	for _, row := range s.rows {
+		if row.Ts >= req.Start && row.Ts <= req.Stop {
+			resp.Data = append(resp.Data, row)
+		}
+	}
+	return &resp, nil
+}
+
+func TestEdgeOnLegacyServer(t *testing.T) {
+	legacy := &legacyServer{}
+	for i, ts := range []int64{1, 2, 3, 3, 4, 5} {
+		legacy.rows = append(legacy.rows, &proto.DBTuple{Ts: ts, Data: []byte(fmt.Sprintf("row %d", i))})
+	}
+	srv := grpc.NewServer()
+	proto.RegisterDBServiceServer(srv, legacy)
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	client, err := dbsdk.NewClient(context.Background(), "bufnet", dbsdk.WithInsecure(), dbsdk.WithDialOptions(grpc.WithContextDialer(dialer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	data := func(rows []*proto.DBTuple) []string {
+		var s []string
+		for _, row := range rows {
+			s = append(s, string(row.Data))
+		}
+		return s
+	}
+	tests := []struct {
+		latest bool
+		n      int
+		want   []string
+	}{
+		{latest: true, n: 3, want: []string{"row 5", "row 4", "row 3"}},
+		{latest: true, n: 10, want: []string{"row 5", "row 4", "row 3", "row 2", "row 1", "row 0"}},
+		{n: 2, want: []string{"row 0", "row 1"}},
+		{n: 10, want: []string{"row 0", "row 1", "row 2", "row 3", "row 4", "row 5"}},
+	}
+	for _, tt := range tests {
+		edge := client.Earliest
+		if tt.latest {
+			edge = client.Latest
+		}
+		rows, err := edge(context.Background(), "t", tt.n)
+		if err != nil {
+			t.Fatalf("latest=%v n=%d: %v", tt.latest, tt.n, err)
+		}
+		if got := data(rows); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("latest=%v n=%d: got %q, want %q", tt.latest, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	srv := grpc.NewServer()
+	proto.RegisterDBServiceServer(srv, server.New(server.NewMemoryStorage()))
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	// the server can't be reached until up is set
+	var up int32
+	dialer := func(context.Context, string) (net.Conn, error) {
+		if atomic.LoadInt32(&up) == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return lis.Dial()
+	}
+	retry := grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoff.Config{BaseDelay: 10 * time.Millisecond, Multiplier: 1.6, MaxDelay: 50 * time.Millisecond}, MinConnectTimeout: time.Second})
+	client, err := dbsdk.NewClient(context.Background(), "bufnet", dbsdk.WithInsecure(), dbsdk.WithNonBlockingDial(),
+		dbsdk.WithDialOptions(grpc.WithContextDialer(dialer), retry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.WaitForReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v while the server is down, want the deadline", err)
+	}
+	if state := client.State(); state == connectivity.Ready {
+		t.Fatal("ready while the server is down")
+	}
+
+	atomic.StoreInt32(&up, 1)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitForReady(ctx); err != nil {
+		t.Fatalf("waiting for the server: %v", err)
+	}
+	if state := client.State(); state != connectivity.Ready {
+		t.Fatalf("got state %s, want READY", state)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitForReady(ctx); err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("got %v after Close, want the connection closed error", err)
+	}
+	if state := client.State(); state != connectivity.Shutdown {
+		t.Fatalf("got state %s after Close, want SHUTDOWN", state)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv, client := dbsdktest.Start(t, dbsdk.WithRequestTimeout(50*time.Millisecond))
+	srv.SetLatency("Append", 200*time.Millisecond)
+	srv.SetLatency("Query", 200*time.Millisecond)
+
+	// without a deadline of its own the request gets the client's timeout
+	if err := client.AppendContext(context.Background(), "t", time.Unix(0, 1), []byte("row")); !errors.Is(err, dbsdk.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want ErrDeadlineExceeded", err)
+	}
+	if _, err := client.QueryContext(context.Background(), "t", time.Unix(0, 0), time.Unix(0, 2)); !errors.Is(err, dbsdk.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want ErrDeadlineExceeded", err)
+	}
+
+	// a longer deadline of the caller replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.AppendContext(ctx, "t", time.Unix(0, 1), []byte("row")); err != nil {
+		t.Fatalf("append with the caller's deadline: %v", err)
+	}
+	rows, err := client.QueryContext(ctx, "t", time.Unix(0, 0), time.Unix(0, 2))
+	if err != nil {
+		t.Fatalf("query with the caller's deadline: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// errKeyReused is returned for a request whose idempotency key was used by a different request
+var errKeyReused = errors.New("idempotency key was already used for a different request")
+
+// dedupe remembers the idempotency keys of successful appends for a window
+// so a retried append is applied only once
+// Keys are scoped to the method and the table, and a key that comes back with
+// different rows is rejected rather than taken for a retry
+type dedupe struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+	order   []*dedupeEntry
+}
+
+type dedupeEntry struct {
+	key   string
+	table string
+	sum   [sha256.Size]byte
+	at    time.Time
+	done  chan struct{}
+	err   error
+}
+
+func newDedupe(window time.Duration) *dedupe {
+	return &dedupe{window: window, entries: make(map[string]*dedupeEntry)}
+}
+
+// do runs fn unless a request with the same key already succeeded within the window
+// A duplicate of a request still in flight waits for its outcome and runs fn itself if it failed
+// fn gets the key to store with the rows, its Key is empty when the request isn't deduplicated
+func (d *dedupe) do(method, table, key string, rows []*proto.DBTuple, fn func(AppendKey) error) error {
+	if key == "" || d.window <= 0 {
+		return fn(AppendKey{})
+	}
+	appendKey := AppendKey{Method: method, Key: key, Sum: rowsSum(rows)}
+	key = entryKey(method, table, key)
+
+	for {
+		d.mu.Lock()
+		d.expire(time.Now())
+		e, ok := d.entries[key]
+		if !ok {
+			appendKey.At = time.Now()
+			e = &dedupeEntry{key: key, table: table, sum: appendKey.Sum, at: appendKey.At, done: make(chan struct{})}
+			d.entries[key] = e
+			d.order = append(d.order, e)
+			d.mu.Unlock()
+
+			e.err = fn(appendKey)
+			if e.err != nil {
+				d.mu.Lock()
+				if d.entries[key] == e {
+					delete(d.entries, key)
+				}
+				d.mu.Unlock()
+			}
+			close(e.done)
+			return e.err
+		}
+		d.mu.Unlock()
+
+		if e.sum != appendKey.Sum {
+			return errKeyReused
+		}
+		<-e.done
+		if e.err == nil {
+			return nil
+		}
+	}
+}
+
+// restore remembers the keys a storage kept from before a restart, as requests that succeeded
+func (d *dedupe) restore(keys map[string][]AppendKey) {
+	if d.window <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	done := make(chan struct{})
+	close(done)
+	for table, keys := range keys {
+		for _, k := range keys {
+			e := &dedupeEntry{key: entryKey(k.Method, table, k.Key), table: table, sum: k.Sum, at: k.At, done: done}
+			d.entries[e.key] = e
+			d.order = append(d.order, e)
+		}
+	}
+	// expire walks the keys oldest first
+	sort.SliceStable(d.order, func(i, j int) bool { return d.order[i].at.Before(d.order[j].at) })
+	d.expire(time.Now())
+}
+
+func entryKey(method, table, key string) string {
+	return method + "\x00" + table + "\x00" + key
+}
+
+// forget drops the keys of a table, so appends to a purged table are not taken for retries
+// of the rows it lost
+func (d *dedupe) forget(table string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, e := range d.entries {
+		if e.table == table {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// expire forgets the keys older than the window, the caller holds d.mu
+func (d *dedupe) expire(now time.Time) {
+	n := 0
+	for n < len(d.order) && now.Sub(d.order[n].at) > d.window {
+		e := d.order[n]
+		if d.entries[e.key] == e {
+			delete(d.entries, e.key)
+		}
+		d.order[n] = nil
+		n++
+	}
+	d.order = d.order[n:]
+}
+
+// rowsSum hashes the rows of a request to tell a retry from a different request with the same key
+func rowsSum(rows []*proto.DBTuple) [sha256.Size]byte {
+	h := sha256.New()
+	var b [16]byte
+	for _, row := range rows {
+		binary.BigEndian.PutUint64(b[:8], uint64(row.Ts))
+		binary.BigEndian.PutUint64(b[8:], uint64(len(row.Data)))
+		h.Write(b[:])
+		h.Write(row.Data)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// subscriberBuffer is how many rows a subscriber can fall behind before it is dropped
+const subscriberBuffer = 1024
+
+// hub fans appended rows out to the subscribers of each table
+type hub struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+// topic holds the subscribers of a table
+// Its lock is held while appending so a subscriber registered under it
+// sees every row either in its replay or as a live row, never both
+type topic struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	from    int64
+	rows    chan *proto.DBTuple
+	dropped chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{topics: make(map[string]*topic)}
+}
+
+func (h *hub) topic(table string) *topic {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[table]
+	if !ok {
+		t = &topic{subs: make(map[*subscriber]struct{})}
+		h.topics[table] = t
+	}
+	return t
+}
+
+// subscribe registers a subscriber for the rows at or after from, the caller holds t.mu
+func (t *topic) subscribe(from int64) *subscriber {
+	sub := &subscriber{
+		from:    from,
+		rows:    make(chan *proto.DBTuple, subscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+	t.subs[sub] = struct{}{}
+	return sub
+}
+
+func (t *topic) unsubscribe(sub *subscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, sub)
+}
+
+// publish hands the rows to every subscriber, the caller holds t.mu
+// Subscribers whose buffer is full are dropped rather than blocking appends
+func (t *topic) publish(rows []*proto.DBTuple) {
+	for sub := range t.subs {
+	send:
+		for _, row := range rows {
+			if row.Ts < sub.from {
+				continue
+			}
+
+			select {
+			case sub.rows <- row:
+			default:
+				delete(t.subs, sub)
+				close(sub.dropped)
+				break send
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// MemoryStorage keeps tables in memory, its contents are lost when the process exits
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	tables map[string][]*proto.DBTuple
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{tables: make(map[string][]*proto.DBTuple)}
+}
+
+// Append implements Storage
+func (m *MemoryStorage) Append(table string, rows []*proto.DBTuple) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data := m.tables[table]
+	for _, row := range rows {
+		if n := len(data); n == 0 || data[n-1].Ts <= row.Ts {
+			data = append(data, row)
+			continue
+		}
+
+		// out of order rows go into a new slice so the snapshots held by iterators don't change
+		i := sort.Search(len(data), func(i int) bool { return data[i].Ts > row.Ts })
+		next := make([]*proto.DBTuple, 0, len(data)+len(rows))
+		next = append(next, data[:i]...)
+		next = append(next, row)
+		data = append(next, data[i:]...)
+	}
+
+	if data == nil {
+		data = []*proto.DBTuple{}
+	}
+	m.tables[table] = data
+	return nil
+}
+
+// Scan implements Storage
+func (m *MemoryStorage) Scan(table string, start, stop int64, descending bool) (Iterator, error) {
+	m.mu.RLock()
+	data, ok := m.tables[table]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+
+	lo := sort.Search(len(data), func(i int) bool { return data[i].Ts >= start })
+	hi := sort.Search(len(data), func(i int) bool { return data[i].Ts > stop })
+	if hi < lo {
+		hi = lo
+	}
+	return &sliceIterator{rows: data[lo:hi], pos: -1, descending: descending}, nil
+}
+
+// Stats implements Storage
+func (m *MemoryStorage) Stats(table string) (*proto.TableStatTuple, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.tables[table]
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+
+	stats := proto.TableStatTuple{RowCount: int64(len(data))}
+	if len(data) > 0 {
+		stats.OldestTS = data[0].Ts
+		stats.NewestTS = data[len(data)-1].Ts
+	}
+	return &stats, nil
+}
+
+// Tables implements Storage
+func (m *MemoryStorage) Tables() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Purge implements Storage
+func (m *MemoryStorage) Purge(table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tables[table]; !ok {
+		return ErrTableNotFound
+	}
+	delete(m.tables, table)
+	return nil
+}
+
+// sliceIterator walks a snapshot of sorted rows in either direction
+type sliceIterator struct {
+	rows       []*proto.DBTuple
+	pos        int
+	descending bool
+}
+
+func (it *sliceIterator) Next() bool {
+	it.pos++
+	return it.pos < len(it.rows)
+}
+
+func (it *sliceIterator) Row() *proto.DBTuple {
+	if it.descending {
+		return it.rows[len(it.rows)-1-it.pos]
+	}
+	return it.rows[it.pos]
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.rows = nil
+	return nil
+}
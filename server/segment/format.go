@@ -0,0 +1,280 @@
+package segment
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// A segment file starts with a fixed size header followed by records
+//
+//	header:  magic "ADBS" | version uint16 | flags uint16 | created unix nanos int64 |
+//	         row count int64 | min ts int64 | max ts int64 | valid size int64 |
+//	         reserved [12]byte | crc32c of the preceding bytes uint32
+//	record:  flags and payload length uint32 | crc32c of payload uint32 | payload (marshaled proto.DBTuple)
+//	key:     a record with recordKey set, its payload is
+//	         unix nanos int64 | rows sha256 [32]byte | method length uint16 | method | idempotency key
+//
+// The rows of one append are written as consecutive records, every record but the
+// last has recordMore set in the top bit of its length, so recovery can drop a
+// batch that was only partly written. An append with an idempotency key starts
+// with a key record, so the key is kept or dropped with its rows.
+//
+// The metadata in the header is only written when a segment is sealed, the
+// active segment's metadata is rebuilt by scanning it on open.
+//
+// Next to every sealed segment an index file holds a sparse ts -> file offset index
+//
+//	index:   entries of ts int64 | offset int64, then crc32c of the entries uint32
+//
+// All integers are little endian
+const (
+	magic            = "ADBS"
+	formatVersion    = 1
+	headerSize       = 64
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+	// recordMore marks a record followed by another record of the same batch
+	recordMore = 1 << 31
+	// recordKey marks a record holding the idempotency key of its batch rather than a row
+	recordKey     = 1 << 30
+	keyHeaderSize = 8 + sha256.Size + 2
+
+	flagSealed = 1 << 0
+	flagSorted = 1 << 1
+
+	indexEntrySize = 16
+	// indexInterval is the number of record bytes between two index entries
+	indexInterval = 4 << 10
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn marks a record that was only partly written or is corrupt
+var errTorn = errors.New("torn or corrupt record")
+
+// header is the decoded header of a segment file
+type header struct {
+	flags   uint16
+	created time.Time
+	count   int64
+	minTs   int64
+	maxTs   int64
+	size    int64
+}
+
+func encodeHeader(h header) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, magic)
+	binary.LittleEndian.PutUint16(buf[4:], formatVersion)
+	binary.LittleEndian.PutUint16(buf[6:], h.flags)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(h.created.UnixNano()))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(h.count))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(h.minTs))
+	binary.LittleEndian.PutUint64(buf[32:], uint64(h.maxTs))
+	binary.LittleEndian.PutUint64(buf[40:], uint64(h.size))
+	binary.LittleEndian.PutUint32(buf[60:], crc32.Checksum(buf[:60], crcTable))
+	return buf
+}
+
+// decodeHeader decodes the start of a segment file, io.ErrUnexpectedEOF when buf is shorter than a header
+func decodeHeader(buf []byte) (header, error) {
+	if len(buf) < headerSize {
+		return header{}, io.ErrUnexpectedEOF
+	}
+	if string(buf[:4]) != magic {
+		return header{}, errors.New("not a segment file")
+	}
+	if version := binary.LittleEndian.Uint16(buf[4:]); version != formatVersion {
+		return header{}, fmt.Errorf("unsupported segment version %d", version)
+	}
+	if crc32.Checksum(buf[:60], crcTable) != binary.LittleEndian.Uint32(buf[60:]) {
+		return header{}, errors.New("segment header checksum mismatch")
+	}
+
+	return header{
+		flags:   binary.LittleEndian.Uint16(buf[6:]),
+		created: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:]))),
+		count:   int64(binary.LittleEndian.Uint64(buf[16:])),
+		minTs:   int64(binary.LittleEndian.Uint64(buf[24:])),
+		maxTs:   int64(binary.LittleEndian.Uint64(buf[32:])),
+		size:    int64(binary.LittleEndian.Uint64(buf[40:])),
+	}, nil
+}
+
+// indexEntry locates the record starting at offset, whose timestamp is ts
+type indexEntry struct {
+	ts     int64
+	offset int64
+}
+
+func encodeIndex(index []indexEntry) []byte {
+	buf := make([]byte, len(index)*indexEntrySize+4)
+	for i, e := range index {
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize:], uint64(e.ts))
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize+8:], uint64(e.offset))
+	}
+	n := len(index) * indexEntrySize
+	binary.LittleEndian.PutUint32(buf[n:], crc32.Checksum(buf[:n], crcTable))
+	return buf
+}
+
+func decodeIndex(buf []byte) ([]indexEntry, error) {
+	n := len(buf) - 4
+	if n < 0 || n%indexEntrySize != 0 || crc32.Checksum(buf[:n], crcTable) != binary.LittleEndian.Uint32(buf[n:]) {
+		return nil, errors.New("corrupt segment index")
+	}
+
+	index := make([]indexEntry, n/indexEntrySize)
+	for i := range index {
+		index[i].ts = int64(binary.LittleEndian.Uint64(buf[i*indexEntrySize:]))
+		index[i].offset = int64(binary.LittleEndian.Uint64(buf[i*indexEntrySize+8:]))
+	}
+	return index, nil
+}
+
+// appendRecord appends the framed encoding of row to buf, more is set on all but the last row of a batch
+func appendRecord(buf []byte, row *proto.DBTuple, more bool) ([]byte, error) {
+	payload, err := protobuf.Marshal(row)
+	if err != nil {
+		return buf, err
+	}
+	if len(payload) > maxRecordSize {
+		return buf, fmt.Errorf("row of %d bytes is larger than the %d byte limit", len(payload), maxRecordSize)
+	}
+
+	size := uint32(len(payload))
+	if more {
+		size |= recordMore
+	}
+	return appendFrame(buf, size, payload), nil
+}
+
+// appendKeyRecord appends the key record that starts a batch to buf
+func appendKeyRecord(buf []byte, key server.AppendKey) ([]byte, error) {
+	if len(key.Method) > 0xffff || keyHeaderSize+len(key.Method)+len(key.Key) > maxRecordSize {
+		return buf, errors.New("idempotency key is too long")
+	}
+	payload := make([]byte, keyHeaderSize, keyHeaderSize+len(key.Method)+len(key.Key))
+	binary.LittleEndian.PutUint64(payload[0:], uint64(key.At.UnixNano()))
+	copy(payload[8:], key.Sum[:])
+	binary.LittleEndian.PutUint16(payload[8+sha256.Size:], uint16(len(key.Method)))
+	payload = append(payload, key.Method...)
+	payload = append(payload, key.Key...)
+	return appendFrame(buf, uint32(len(payload))|recordKey|recordMore, payload), nil
+}
+
+func decodeKey(payload []byte) (server.AppendKey, bool) {
+	if len(payload) < keyHeaderSize {
+		return server.AppendKey{}, false
+	}
+	key := server.AppendKey{At: time.Unix(0, int64(binary.LittleEndian.Uint64(payload[0:])))}
+	copy(key.Sum[:], payload[8:])
+	n := int(binary.LittleEndian.Uint16(payload[8+sha256.Size:]))
+	rest := payload[keyHeaderSize:]
+	if len(rest) < n {
+		return server.AppendKey{}, false
+	}
+	key.Method, key.Key = string(rest[:n]), string(rest[n:])
+	return key, true
+}
+
+// appendFrame appends a record holding payload, size is its length and flags
+func appendFrame(buf []byte, size uint32, payload []byte) []byte {
+	var hdr [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], size)
+	binary.LittleEndian.PutUint32(hdr[4:], crc32.Checksum(payload, crcTable))
+	buf = append(buf, hdr[:]...)
+	return append(buf, payload...)
+}
+
+// recordReader reads the records of a segment one at a time
+type recordReader struct {
+	r       *bufio.Reader
+	offset  int64
+	payload []byte
+	// more is set when the last record read isn't the end of its batch
+	more bool
+	// onKey, when set, gets the key records, which next skips
+	onKey func(server.AppendKey)
+}
+
+// newRecordReader reads the records in r, offset is the position of r in the file
+func newRecordReader(r io.Reader, offset int64) *recordReader {
+	return &recordReader{r: bufio.NewReaderSize(r, 64<<10), offset: offset}
+}
+
+// next returns the next row, io.EOF at a clean end of the records
+// and errTorn when the remaining bytes don't hold a whole valid record
+func (rr *recordReader) next() (*proto.DBTuple, error) {
+	for {
+		row, err := rr.record()
+		if row != nil || err != nil {
+			return row, err
+		}
+	}
+}
+
+// record reads the next record, it returns no row and no error for a key record
+func (rr *recordReader) record() (*proto.DBTuple, error) {
+	var hdr [recordHeaderSize]byte
+	if _, err := io.ReadFull(rr.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[0:])
+	more, isKey := size&recordMore != 0, size&recordKey != 0
+	size &^= recordMore | recordKey
+	if size > maxRecordSize {
+		return nil, errTorn
+	}
+	if cap(rr.payload) < int(size) {
+		rr.payload = make([]byte, size)
+	}
+	payload := rr.payload[:size]
+	if _, err := io.ReadFull(rr.r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return nil, errTorn
+	}
+
+	if isKey {
+		key, ok := decodeKey(payload)
+		if !ok {
+			return nil, errTorn
+		}
+		rr.offset += recordHeaderSize + int64(size)
+		rr.more = more
+		if rr.onKey != nil {
+			rr.onKey(key)
+		}
+		return nil, nil
+	}
+
+	var row proto.DBTuple
+	if err := protobuf.Unmarshal(payload, &row); err != nil {
+		return nil, errTorn
+	}
+	rr.offset += recordHeaderSize + int64(size)
+	rr.more = more
+	return &row, nil
+}
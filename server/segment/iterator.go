@@ -0,0 +1,345 @@
+package segment
+
+import (
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// iterator walks the rows of a table snapshot in timestamp order
+//
+// Every segment overlapping the range gets a cursor and the cursors are merged,
+// rows sharing a timestamp across segments come out in segment order. A cursor only
+// joins the merge once the rows reach its timestamps and opens its file then, so
+// segments that follow each other in time are read one at a time. Sorted segments are entered through their
+// sparse index, ascending they stream from the file and descending they hold one
+// block of the index at a time; only the matching rows of an unsorted segment are
+// sorted in memory
+type iterator struct {
+	table       *table
+	start, stop int64
+	descending  bool
+
+	// pending cursors, in the order they join the merge
+	pending []*cursor
+	merge   cursorHeap
+
+	row    *proto.DBTuple
+	err    error
+	done   bool
+	closed bool
+}
+
+// newIterator scans the segments of table overlapping the range, the scan was counted in
+// table.scans and Close ends it
+func newIterator(t *table, segs []snapshot, start, stop int64, descending bool) *iterator {
+	it := &iterator{table: t, start: start, stop: stop, descending: descending}
+	it.merge.descending = descending
+	for i, seg := range segs {
+		it.pending = append(it.pending, &cursor{table: t, seg: seg, order: i, start: start, stop: stop, descending: descending})
+	}
+	sort.SliceStable(it.pending, func(i, j int) bool {
+		if descending {
+			return it.pending[i].seg.maxTs > it.pending[j].seg.maxTs
+		}
+		return it.pending[i].seg.minTs < it.pending[j].seg.minTs
+	})
+	return it
+}
+
+func (it *iterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	// the cursors that may hold a row at or before the head of the merge join it first
+	for len(it.pending) > 0 && (len(it.merge.cursors) == 0 || it.reached(it.pending[0])) {
+		c := it.pending[0]
+		it.pending[0] = nil
+		it.pending = it.pending[1:]
+		if !it.advance(c) {
+			return false
+		}
+		if c.row != nil {
+			heap.Push(&it.merge, c)
+		}
+	}
+
+	if len(it.merge.cursors) == 0 {
+		it.done = true
+		it.row = nil
+		return false
+	}
+
+	c := it.merge.cursors[0]
+	it.row = c.row
+	if !it.advance(c) {
+		return false
+	}
+	if c.row != nil {
+		heap.Fix(&it.merge, 0)
+	} else {
+		heap.Pop(&it.merge)
+	}
+	return true
+}
+
+// reached reports whether the rows of the merge got to the timestamps of a pending cursor
+func (it *iterator) reached(c *cursor) bool {
+	head := it.merge.cursors[0].row.Ts
+	if it.descending {
+		return c.seg.maxTs >= head
+	}
+	return c.seg.minTs <= head
+}
+
+// advance moves a cursor to its next row, closing its file at the end
+func (it *iterator) advance(c *cursor) bool {
+	if err := c.next(); err != nil {
+		it.err = err
+		it.row = nil
+		c.close()
+		return false
+	}
+	if c.row == nil {
+		c.close()
+	}
+	return true
+}
+
+func (it *iterator) Row() *proto.DBTuple {
+	return it.row
+}
+
+func (it *iterator) Err() error {
+	return it.err
+}
+
+func (it *iterator) Close() error {
+	it.done = true
+	it.row = nil
+	for _, c := range it.pending {
+		c.close()
+	}
+	for _, c := range it.merge.cursors {
+		c.close()
+	}
+	it.pending, it.merge.cursors = nil, nil
+
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	return it.table.endScan()
+}
+
+// cursor reads the rows of one segment in the range, in the order of the scan
+type cursor struct {
+	table       *table
+	seg         snapshot
+	file        *os.File
+	order       int
+	start, stop int64
+	descending  bool
+
+	// row is the current row, nil once the cursor is exhausted
+	row *proto.DBTuple
+
+	started bool
+	reader  *recordReader
+	end     int64
+
+	// buffered rows, already in the order they are returned
+	buf []*proto.DBTuple
+	pos int
+	// block is the index entry starting the next block a descending cursor reads
+	block int
+}
+
+func (c *cursor) next() error {
+	if !c.started {
+		c.started = true
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if c.pos < len(c.buf) {
+			c.row = c.buf[c.pos]
+			c.buf[c.pos] = nil
+			c.pos++
+			return nil
+		}
+		c.buf, c.pos = c.buf[:0], 0
+
+		if c.reader == nil {
+			c.row = nil
+			return nil
+		}
+		if c.descending {
+			if err := c.loadBlock(); err != nil {
+				return err
+			}
+			continue
+		}
+		return c.nextStreamed()
+	}
+}
+
+// open opens the segment file and positions the cursor at the first rows of the range
+func (c *cursor) open() error {
+	f, err := c.table.open(c.seg)
+	if err != nil {
+		return err
+	}
+	c.file = f
+
+	if !c.seg.sorted {
+		// the rows can be anywhere in the segment, read the matching ones and sort them
+		if err := c.read(headerSize, c.seg.size, false); err != nil {
+			return err
+		}
+		sort.SliceStable(c.buf, func(i, j int) bool { return c.buf[i].Ts < c.buf[j].Ts })
+		if c.descending {
+			reverse(c.buf)
+		}
+		return nil
+	}
+
+	if c.descending {
+		// the block before the first entry past stop, rows sharing stop may continue into later blocks
+		index := c.seg.index
+		c.block = sort.Search(len(index), func(i int) bool { return index[i].ts > c.stop }) - 1
+		c.reader = newRecordReader(nil, 0)
+		return nil
+	}
+
+	from := c.seg.seek(c.start)
+	c.reader = newRecordReader(io.NewSectionReader(c.file, from, c.seg.size-from), from)
+	c.end = c.seg.size
+	return nil
+}
+
+// nextStreamed reads the next matching row of an ascending cursor over a sorted segment
+func (c *cursor) nextStreamed() error {
+	for c.reader.offset < c.end {
+		row, err := c.reader.next()
+		if err != nil {
+			return err
+		}
+		if row.Ts < c.start {
+			continue
+		}
+		if row.Ts > c.stop {
+			break
+		}
+		c.row = row
+		return nil
+	}
+	c.row, c.reader = nil, nil
+	return nil
+}
+
+// loadBlock buffers the matching rows of the next index block of a descending cursor, newest first
+func (c *cursor) loadBlock() error {
+	index := c.seg.index
+	if c.block < 0 {
+		c.reader = nil
+		return nil
+	}
+
+	from, to := index[c.block].offset, c.seg.size
+	if c.block+1 < len(index) {
+		to = index[c.block+1].offset
+	}
+	// the blocks before one starting below start hold nothing in the range
+	if index[c.block].ts < c.start {
+		c.block = -1
+	} else {
+		c.block--
+	}
+
+	if err := c.read(from, to, true); err != nil {
+		return err
+	}
+	reverse(c.buf)
+	return nil
+}
+
+// read buffers the rows in the range between the offsets from and to
+// A reused reader keeps its buffer from one block to the next
+func (c *cursor) read(from, to int64, reuse bool) error {
+	section := io.NewSectionReader(c.file, from, to-from)
+	rr := c.reader
+	if reuse && rr != nil {
+		rr.r.Reset(section)
+		rr.offset = from
+	} else {
+		rr = newRecordReader(section, from)
+	}
+
+	for rr.offset < to {
+		row, err := rr.next()
+		if err == io.EOF {
+			// the range ended with the key record of the next batch
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if row.Ts >= c.start && row.Ts <= c.stop {
+			c.buf = append(c.buf, row)
+		}
+	}
+	return nil
+}
+
+func (c *cursor) close() {
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file, c.reader, c.buf = nil, nil, nil
+}
+
+// cursorHeap orders the cursors of a merge by their current row
+type cursorHeap struct {
+	cursors    []*cursor
+	descending bool
+}
+
+func (h *cursorHeap) Len() int { return len(h.cursors) }
+
+func (h *cursorHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if a.row.Ts != b.row.Ts {
+		return (a.row.Ts < b.row.Ts) != h.descending
+	}
+	// rows sharing a timestamp were appended to the older segment first
+	return (a.order < b.order) != h.descending
+}
+
+func (h *cursorHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *cursorHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(*cursor)) }
+
+func (h *cursorHeap) Pop() interface{} {
+	n := len(h.cursors) - 1
+	c := h.cursors[n]
+	h.cursors[n] = nil
+	h.cursors = h.cursors[:n]
+	return c
+}
+
+func (s snapshot) overlaps(start, stop int64) bool {
+	return s.count > 0 && s.maxTs >= start && s.minTs <= stop
+}
+
+func reverse(rows []*proto.DBTuple) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+}
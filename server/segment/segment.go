@@ -0,0 +1,431 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+)
+
+const (
+	segmentExt = ".seg"
+	indexExt   = ".idx"
+)
+
+// segment is one file of a table
+type segment struct {
+	seq     uint64
+	path    string
+	created time.Time
+
+	// size is the length of the valid part of the file
+	size  int64
+	count int64
+	minTs int64
+	maxTs int64
+	// sorted is true while the records were appended in timestamp order
+	sorted bool
+	// sealed segments are complete, their metadata lives in the header
+	sealed bool
+
+	// index is sparse, it has an entry every indexInterval bytes of records
+	index       []indexEntry
+	lastIndexed int64
+
+	// file is open for appending on the active segment only
+	file  *os.File
+	dirty bool
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return seq, err == nil
+}
+
+func (s *segment) indexPath() string {
+	return strings.TrimSuffix(s.path, segmentExt) + indexExt
+}
+
+// createSegment starts a new empty segment file
+func createSegment(dir string, seq uint64, now time.Time) (*segment, error) {
+	seg := &segment{
+		seq:     seq,
+		path:    filepath.Join(dir, segmentName(seq)),
+		created: now,
+		size:    headerSize,
+		sorted:  true,
+	}
+
+	f, err := os.OpenFile(seg.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteAt(encodeHeader(seg.header()), 0); err != nil {
+		f.Close()
+		os.Remove(seg.path)
+		return nil, err
+	}
+	seg.file = f
+	return seg, nil
+}
+
+// loadSegment reads the metadata of a segment
+// Sealed segments are trusted, the others are scanned and a torn tail is truncated
+func loadSegment(dir string, seq uint64) (*segment, error) {
+	path := filepath.Join(dir, segmentName(seq))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, headerSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	h, err := decodeHeader(buf[:n])
+	if err == io.ErrUnexpectedEOF {
+		// the process died while creating the segment, start it over
+		return rewriteHeader(path, seq)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	seg := &segment{
+		seq:     seq,
+		path:    path,
+		created: h.created,
+		size:    headerSize,
+		sorted:  true,
+	}
+
+	if h.flags&flagSealed != 0 {
+		seg.sealed = true
+		seg.size, seg.count, seg.minTs, seg.maxTs = h.size, h.count, h.minTs, h.maxTs
+		seg.sorted = h.flags&flagSorted != 0
+
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() >= seg.size {
+			if seg.index, err = loadIndex(seg.indexPath()); err == nil {
+				return seg, nil
+			}
+
+			// the index is only a cache of the records, rebuild it
+			sealed := *seg
+			if err := seg.scan(f, false); err == nil && seg.size == sealed.size && seg.count == sealed.count {
+				return seg, seg.writeIndex()
+			}
+		}
+
+		// the records didn't all reach the disk before a crash, recover what did
+		// and treat the segment as unsealed again
+		seg.sealed = false
+		os.Remove(seg.indexPath())
+		if err := seg.scan(f, true); err != nil {
+			return nil, err
+		}
+		return seg, seg.writeHeader()
+	}
+
+	if err := seg.scan(f, true); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// writeHeader replaces the header on disk with the current metadata
+func (s *segment) writeHeader() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(encodeHeader(s.header()), 0)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// scan rebuilds the metadata and index of the segment from its records
+// With repair set a torn tail is truncated, otherwise it is an error
+// A batch whose last record is missing is torn as a whole, so an append is kept entirely or not at all
+func (s *segment) scan(f *os.File, repair bool) error {
+	s.size, s.count, s.sorted = headerSize, 0, true
+	s.index, s.lastIndexed = nil, 0
+
+	// pending holds the rows of the batch being read and their offsets,
+	// keyed is set once the batch's key record was read
+	var pending []*proto.DBTuple
+	var offsets []int64
+	keyed := false
+
+	rr := newRecordReader(io.NewSectionReader(f, headerSize, 1<<62), headerSize)
+	rr.onKey = func(server.AppendKey) { keyed = true }
+	for {
+		offset := rr.offset
+		row, err := rr.next()
+		if err == io.EOF && (len(pending) > 0 || keyed) {
+			err = errTorn
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err == errTorn && repair {
+			return os.Truncate(s.path, s.size)
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", s.path, offset, err)
+		}
+
+		pending = append(pending, row)
+		offsets = append(offsets, offset)
+		if rr.more {
+			continue
+		}
+		for i, row := range pending {
+			s.add(row, offsets[i])
+		}
+		pending, offsets, keyed = pending[:0], offsets[:0], false
+		s.size = rr.offset
+	}
+}
+
+// keys returns the idempotency keys stored in the segment at or after since
+func (s *segment) keys(since time.Time) ([]server.AppendKey, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []server.AppendKey
+	rr := newRecordReader(io.NewSectionReader(f, headerSize, s.size-headerSize), headerSize)
+	rr.onKey = func(key server.AppendKey) {
+		if !key.At.Before(since) {
+			keys = append(keys, key)
+		}
+	}
+	for {
+		_, err := rr.next()
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s at offset %d: %w", s.path, rr.offset, err)
+		}
+	}
+}
+
+func rewriteHeader(path string, seq uint64) (*segment, error) {
+	seg := &segment{seq: seq, path: path, created: time.Now(), size: headerSize, sorted: true}
+	if err := os.WriteFile(path, encodeHeader(seg.header()), 0644); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+func loadIndex(path string) ([]indexEntry, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeIndex(buf)
+}
+
+// add updates the metadata of the segment for a row appended at offset
+func (s *segment) add(row *proto.DBTuple, offset int64) {
+	if s.count == 0 {
+		s.minTs, s.maxTs = row.Ts, row.Ts
+	} else {
+		if row.Ts < s.maxTs {
+			s.sorted = false
+		}
+		if row.Ts < s.minTs {
+			s.minTs = row.Ts
+		}
+		if row.Ts > s.maxTs {
+			s.maxTs = row.Ts
+		}
+	}
+	s.count++
+
+	if len(s.index) == 0 || offset-s.lastIndexed >= indexInterval {
+		s.index = append(s.index, indexEntry{ts: row.Ts, offset: offset})
+		s.lastIndexed = offset
+	}
+}
+
+func (s *segment) header() header {
+	h := header{
+		created: s.created,
+		count:   s.count,
+		minTs:   s.minTs,
+		maxTs:   s.maxTs,
+		size:    s.size,
+	}
+	if s.sorted {
+		h.flags |= flagSorted
+	}
+	if s.sealed {
+		h.flags |= flagSealed
+	}
+	return h
+}
+
+// openForAppend makes the segment the active one of its table
+func (s *segment) openForAppend() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// write appends encoded records at the end of the valid part of the file
+// On failure the file is cut back so no partial record remains
+func (s *segment) write(buf []byte, sync bool) error {
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.dirty = true
+	if sync {
+		if err := s.sync(); err != nil {
+			s.file.Truncate(s.size)
+			return err
+		}
+	}
+	return nil
+}
+
+// seal records the final metadata in the header, writes the index and closes the file
+func (s *segment) seal() error {
+	if s.sealed {
+		return s.close()
+	}
+	if s.file == nil {
+		if err := s.openForAppend(); err != nil {
+			return err
+		}
+	}
+
+	// the records have to be on disk before a header that vouches for them,
+	// and the header before the index built from it
+	if err := s.sync(); err != nil {
+		return err
+	}
+	s.sealed = true
+	if _, err := s.file.WriteAt(encodeHeader(s.header()), 0); err != nil {
+		s.sealed = false
+		return err
+	}
+	s.dirty = true
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.writeIndex(); err != nil {
+		return err
+	}
+	return s.close()
+}
+
+// writeIndex saves the sparse index, a lost or corrupt index is rebuilt on open
+func (s *segment) writeIndex() error {
+	tmp := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, encodeIndex(s.index), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.indexPath())
+}
+
+// close syncs and closes the file of the active segment
+func (s *segment) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+func (s *segment) sync() error {
+	if s.file == nil || !s.dirty {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// snapshot is the part of a segment visible to a scan
+type snapshot struct {
+	// name is the file name of the segment in its table's directory
+	name   string
+	size   int64
+	count  int64
+	minTs  int64
+	maxTs  int64
+	sorted bool
+	index  []indexEntry
+}
+
+func (s *segment) snapshot() snapshot {
+	return snapshot{
+		name:   filepath.Base(s.path),
+		size:   s.size,
+		count:  s.count,
+		minTs:  s.minTs,
+		maxTs:  s.maxTs,
+		sorted: s.sorted,
+		index:  s.index,
+	}
+}
+
+// seek returns the offset to start reading from to find the rows at or after ts
+func (s snapshot) seek(ts int64) int64 {
+	if !s.sorted {
+		return headerSize
+	}
+
+	// the last entry before ts, rows sharing ts may precede an entry holding it
+	lo, hi := 0, len(s.index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if s.index[mid].ts < ts {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return headerSize
+	}
+	return s.index[lo-1].offset
+}
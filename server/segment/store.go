@@ -0,0 +1,605 @@
+// Package segment is a durable append-only storage engine for the reference server
+//
+// Every table is a directory of segment files holding length prefixed, checksummed records.
+// Rows are only ever appended to the newest segment, which is rolled over by size or age,
+// and a crash can at worst leave a torn batch at its tail, which is truncated on open.
+package segment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+)
+
+// SyncPolicy controls when appended rows are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the segment before an append returns
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the segments written to every sync interval
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// ParseSyncPolicy parses "always", "interval" or "never"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, errors.New("sync policy must be always, interval or never")
+}
+
+// Option configures a Store
+type Option func(*options)
+
+type options struct {
+	syncPolicy     SyncPolicy
+	syncInterval   time.Duration
+	maxSegmentSize int64
+	maxSegmentAge  time.Duration
+	keyWindow      time.Duration
+}
+
+// WithSyncPolicy sets when appends are synced, the default is SyncAlways
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(o *options) {
+		o.syncPolicy = p
+	}
+}
+
+// WithSyncInterval sets how often SyncInterval syncs, it must be positive
+func WithSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// WithMaxSegmentSize rolls over to a new segment once the active one reaches n bytes
+func WithMaxSegmentSize(n int64) Option {
+	return func(o *options) {
+		o.maxSegmentSize = n
+	}
+}
+
+// WithMaxSegmentAge rolls over to a new segment once the active one is older than d, 0 disables it
+func WithMaxSegmentAge(d time.Duration) Option {
+	return func(o *options) {
+		o.maxSegmentAge = d
+	}
+}
+
+// WithKeyWindow sets how far back Open looks for the idempotency keys of appends,
+// it should cover the server's dedupe window, the default of 5 minutes matches the server's default
+func WithKeyWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.keyWindow = d
+	}
+}
+
+// Store keeps tables in segment files under a directory
+type Store struct {
+	dir  string
+	opts options
+
+	mu     sync.Mutex
+	tables map[string]*table
+	closed bool
+	// purges numbers the directories of purged tables
+	purges uint64
+	// keys are the idempotency keys found by Open, by table
+	keys map[string][]server.AppendKey
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ server.KeyedStorage = (*Store)(nil)
+
+const (
+	// maxDirName keeps table directory names under the 255 byte limit of most file systems
+	maxDirName   = 200
+	hashedPrefix = "h-"
+	nameFile     = "name"
+	// purgedPrefix starts the name a purged table's directory is moved to until its scans end
+	purgedPrefix = ".purged-"
+)
+
+// errClosed is returned when using a Store after Close
+var errClosed = errors.New("store is closed")
+
+type table struct {
+	mu       sync.Mutex
+	dir      string
+	segments []*segment
+	purged   bool
+	// scans counts the running scans, the files of a purged table are removed once they end
+	scans int
+
+	rowCount int64
+	oldest   int64
+	newest   int64
+}
+
+// Open loads the tables stored under dir, creating it if needed,
+// and repairs the tail of every table's newest segment
+func Open(dir string, opts ...Option) (*Store, error) {
+	o := options{
+		syncPolicy:     SyncAlways,
+		syncInterval:   time.Second,
+		maxSegmentSize: 64 << 20,
+		keyWindow:      5 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.syncPolicy == SyncInterval && o.syncInterval <= 0 {
+		return nil, errors.New("sync interval must be positive")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, opts: o, tables: make(map[string]*table), keys: make(map[string][]server.AppendKey), stop: make(chan struct{})}
+	since := time.Now().Add(-o.keyWindow)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), purgedPrefix) {
+			// the store stopped before the scans of a purged table ended
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name, ok := tableName(filepath.Join(dir, entry.Name()))
+		if !ok {
+			continue
+		}
+
+		t, keys, err := loadTable(filepath.Join(dir, entry.Name()), since, o.keyWindow > 0)
+		if err != nil {
+			s.closeTables()
+			return nil, err
+		}
+		s.tables[name] = t
+		if len(keys) > 0 {
+			s.keys[name] = keys
+		}
+	}
+
+	if o.syncPolicy == SyncInterval {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// loadTable loads the segments in dir, with withKeys set it also returns the
+// idempotency keys stored at or after since
+func loadTable(dir string, since time.Time, withKeys bool) (*table, []server.AppendKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		if seq, ok := parseSegmentName(entry.Name()); ok {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	t := &table{dir: dir}
+	var keys []server.AppendKey
+	for i, seq := range seqs {
+		seg, err := loadSegment(dir, seq)
+		if err == nil && withKeys {
+			var segKeys []server.AppendKey
+			segKeys, err = recentKeys(seg, since)
+			keys = append(keys, segKeys...)
+		}
+		if err == nil && !seg.sealed {
+			if i == len(seqs)-1 {
+				err = seg.openForAppend()
+			} else {
+				// only the newest segment is appended to, finish sealing the others
+				err = seg.seal()
+			}
+		}
+		if err != nil {
+			t.close()
+			return nil, nil, err
+		}
+		t.segments = append(t.segments, seg)
+		t.addStats(seg.count, seg.minTs, seg.maxTs)
+	}
+	return t, keys, nil
+}
+
+// recentKeys returns the keys stored in a segment at or after since,
+// only the segments written to since then are read
+func recentKeys(seg *segment, since time.Time) ([]server.AppendKey, error) {
+	info, err := os.Stat(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Before(since) {
+		return nil, nil
+	}
+	return seg.keys(since)
+}
+
+func (t *table) addStats(count, minTs, maxTs int64) {
+	if count == 0 {
+		return
+	}
+	if t.rowCount == 0 || minTs < t.oldest {
+		t.oldest = minTs
+	}
+	if t.rowCount == 0 || maxTs > t.newest {
+		t.newest = maxTs
+	}
+	t.rowCount += count
+}
+
+// active returns the segment to append to, rolling over to a new one when needed
+func (t *table) active(o options, now time.Time) (*segment, error) {
+	if n := len(t.segments); n > 0 {
+		seg := t.segments[n-1]
+		full := seg.size >= o.maxSegmentSize
+		old := o.maxSegmentAge > 0 && now.Sub(seg.created) >= o.maxSegmentAge
+		if !full && !old && seg.file != nil {
+			return seg, nil
+		}
+		if err := seg.seal(); err != nil {
+			return nil, err
+		}
+	}
+
+	var seq uint64 = 1
+	if n := len(t.segments); n > 0 {
+		seq = t.segments[n-1].seq + 1
+	}
+	seg, err := createSegment(t.dir, seq, now)
+	if err != nil {
+		return nil, err
+	}
+	t.segments = append(t.segments, seg)
+	return seg, nil
+}
+
+func (t *table) close() error {
+	var err error
+	for _, seg := range t.segments {
+		if cerr := seg.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// table returns the named table, creating it when create is set
+func (s *Store) table(name string, create bool) (*table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errClosed
+	}
+	if t, ok := s.tables[name]; ok {
+		return t, nil
+	}
+	if !create {
+		return nil, server.ErrTableNotFound
+	}
+
+	dir, err := createTableDir(s.dir, name)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{dir: dir}
+	s.tables[name] = t
+	return t, nil
+}
+
+// tableDir returns the directory of a table, the hex encoded name
+// Names too long for a file name are hashed, their directory holds the name in nameFile
+func tableDir(root, name string) string {
+	if dir := hex.EncodeToString([]byte(name)); len(dir) <= maxDirName {
+		return filepath.Join(root, dir)
+	}
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(root, hashedPrefix+hex.EncodeToString(sum[:]))
+}
+
+// createTableDir creates the directory of a new table
+func createTableDir(root, name string) (string, error) {
+	dir := tableDir(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(filepath.Base(dir), hashedPrefix) {
+		return dir, nil
+	}
+
+	// the name is in place before any segment, a directory without it holds no rows
+	tmp := filepath.Join(dir, nameFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(name), 0644); err != nil {
+		return "", err
+	}
+	return dir, os.Rename(tmp, filepath.Join(dir, nameFile))
+}
+
+// tableName returns the name of the table stored in dir, false for directories that aren't tables
+func tableName(dir string) (string, bool) {
+	base := filepath.Base(dir)
+	if !strings.HasPrefix(base, hashedPrefix) {
+		name, err := hex.DecodeString(base)
+		return string(name), err == nil
+	}
+	name, err := os.ReadFile(filepath.Join(dir, nameFile))
+	return string(name), err == nil
+}
+
+// lockTable returns the named table locked, retrying when it is purged under us
+func (s *Store) lockTable(name string, create bool) (*table, error) {
+	for {
+		t, err := s.table(name, create)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		if !t.purged {
+			return t, nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Append implements server.Storage
+func (s *Store) Append(name string, rows []*proto.DBTuple) error {
+	return s.append(name, rows, nil)
+}
+
+// AppendKeyed implements server.KeyedStorage, the key is written in a record ahead of the rows
+func (s *Store) AppendKeyed(name string, rows []*proto.DBTuple, key server.AppendKey) error {
+	return s.append(name, rows, &key)
+}
+
+// Keys implements server.KeyedStorage
+func (s *Store) Keys() map[string][]server.AppendKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make(map[string][]server.AppendKey, len(s.keys))
+	for name, k := range s.keys {
+		keys[name] = k
+	}
+	return keys
+}
+
+func (s *Store) append(name string, rows []*proto.DBTuple, key *server.AppendKey) error {
+	var buf []byte
+	if key != nil && len(rows) > 0 {
+		var err error
+		if buf, err = appendKeyRecord(buf, *key); err != nil {
+			return err
+		}
+	}
+	starts := make([]int64, len(rows))
+	for i, row := range rows {
+		starts[i] = int64(len(buf))
+		var err error
+		if buf, err = appendRecord(buf, row, i < len(rows)-1); err != nil {
+			return err
+		}
+	}
+
+	t, err := s.lockTable(name, true)
+	if err != nil {
+		return err
+	}
+	defer t.mu.Unlock()
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	seg, err := t.active(s.opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := seg.write(buf, s.opts.syncPolicy == SyncAlways); err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		seg.add(row, seg.size+starts[i])
+		t.addStats(1, row.Ts, row.Ts)
+	}
+	seg.size += int64(len(buf))
+	return nil
+}
+
+// Scan implements server.Storage
+// A segment file is only open while its cursor is in the merge, and the scan keeps a purge
+// from removing the files it has yet to read until it is closed
+func (s *Store) Scan(name string, start, stop int64, descending bool) (server.Iterator, error) {
+	t, err := s.lockTable(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer t.mu.Unlock()
+
+	var snaps []snapshot
+	for _, seg := range t.segments {
+		if snap := seg.snapshot(); snap.overlaps(start, stop) {
+			snaps = append(snaps, snap)
+		}
+	}
+	t.scans++
+	return newIterator(t, snaps, start, stop, descending), nil
+}
+
+// open opens a segment file of the table for a scan, wherever a purge moved the table to
+func (t *table) open(seg snapshot) (*os.File, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return os.Open(filepath.Join(t.dir, seg.name))
+}
+
+// endScan removes the files of a purged table once its last scan ends
+func (t *table) endScan() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.scans--
+	if t.scans == 0 && t.purged {
+		return os.RemoveAll(t.dir)
+	}
+	return nil
+}
+
+// Stats implements server.Storage, it is served from the segment metadata
+func (s *Store) Stats(name string) (*proto.TableStatTuple, error) {
+	t, err := s.lockTable(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer t.mu.Unlock()
+
+	return &proto.TableStatTuple{RowCount: t.rowCount, OldestTS: t.oldest, NewestTS: t.newest}, nil
+}
+
+// Tables implements server.Storage
+func (s *Store) Tables() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Purge implements server.Storage
+func (s *Store) Purge(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tables[name]
+	if !ok {
+		return server.ErrTableNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// the table is moved out of the way at once, so it can be created again while scans still read it
+	t.close()
+	s.purges++
+	trash := filepath.Join(s.dir, purgedPrefix+strconv.FormatUint(s.purges, 10)+"-"+filepath.Base(t.dir))
+	if err := os.Rename(t.dir, trash); err != nil {
+		return err
+	}
+	t.dir = trash
+	t.purged = true
+	delete(s.tables, name)
+	delete(s.keys, name)
+	if t.scans == 0 {
+		return os.RemoveAll(trash)
+	}
+	return nil
+}
+
+// Close syncs and closes every table, the store can't be used afterwards
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	s.wg.Wait()
+	return s.closeTables()
+}
+
+func (s *Store) closeTables() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, t := range s.tables {
+		t.mu.Lock()
+		if terr := t.close(); err == nil {
+			err = terr
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// syncLoop syncs the active segments on the SyncInterval policy
+func (s *Store) syncLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.syncAll()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Store) syncAll() {
+	s.mu.Lock()
+	tables := make([]*table, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	s.mu.Unlock()
+
+	for _, t := range tables {
+		t.mu.Lock()
+		if n := len(t.segments); n > 0 {
+			// a failed sync leaves the segment dirty so the next tick tries again
+			t.segments[n-1].sync()
+		}
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,813 @@
+package segment
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+)
+
+func openStore(t *testing.T, dir string, opts ...Option) *Store {
+	t.Helper()
+	s, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return s
+}
+
+func appendTs(t *testing.T, s *Store, table string, ts ...int64) {
+	t.Helper()
+	for _, v := range ts {
+		row := &proto.DBTuple{Ts: v, Data: []byte(fmt.Sprintf("row %d", v))}
+		if err := s.Append(table, []*proto.DBTuple{row}); err != nil {
+			t.Fatalf("append %d: %v", v, err)
+		}
+	}
+}
+
+func scanTs(t *testing.T, s *Store, table string, start, stop int64, descending bool) []int64 {
+	t.Helper()
+	it, err := s.Scan(table, start, stop, descending)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	defer it.Close()
+
+	var ts []int64
+	for it.Next() {
+		ts = append(ts, it.Row().Ts)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return ts
+}
+
+func segmentFiles(t *testing.T, dir, table string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%x", table), "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func seq(n int) []int64 {
+	ts := make([]int64, n)
+	for i := range ts {
+		ts[i] = int64(i + 1)
+	}
+	return ts
+}
+
+func TestSealedSegmentTornBeforeSync(t *testing.T) {
+	for _, dropIndex := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dropIndex=%v", dropIndex), func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, dir, WithSyncPolicy(SyncNever), WithMaxSegmentSize(headerSize+200))
+			appendTs(t, s, "t", seq(29)...)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// a crash lost the end of the records of a segment that was already sealed
+			paths := segmentFiles(t, dir, "t")
+			if len(paths) < 2 {
+				t.Fatalf("expected several segments, got %d", len(paths))
+			}
+			info, err := os.Stat(paths[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(paths[0], info.Size()-10); err != nil {
+				t.Fatal(err)
+			}
+			if dropIndex {
+				os.Remove(strings.TrimSuffix(paths[0], segmentExt) + indexExt)
+			}
+
+			s = openStore(t, dir)
+			defer s.Close()
+			rows := scanTs(t, s, "t", 0, 100, false)
+			stats, err := s.Stats("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(rows)) != stats.RowCount {
+				t.Fatalf("scanned %d rows, stats report %d", len(rows), stats.RowCount)
+			}
+			if len(rows) != 28 {
+				t.Fatalf("expected the torn row to be dropped, scanned %d rows", len(rows))
+			}
+
+			// the repaired segment is sealed again and the table stays appendable
+			appendTs(t, s, "t", 30)
+			if got := scanTs(t, s, "t", 30, 30, false); len(got) != 1 {
+				t.Fatalf("append after recovery: %v", got)
+			}
+		})
+	}
+}
+
+func TestTruncatedActiveTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	appendTs(t, s, "t", seq(10)...)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the process died half way through writing the last record
+	paths := segmentFiles(t, dir, "t")
+	info, err := os.Stat(paths[len(paths)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(paths[len(paths)-1], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	defer s.Close()
+	if got := scanTs(t, s, "t", 0, 100, false); len(got) != 9 || got[8] != 9 {
+		t.Fatalf("expected rows 1 to 9, got %v", got)
+	}
+	stats, err := s.Stats("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RowCount != 9 || stats.OldestTS != 1 || stats.NewestTS != 9 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	appendTs(t, s, "t", 10)
+	if got := scanTs(t, s, "t", 0, 100, false); len(got) != 10 || got[9] != 10 {
+		t.Fatalf("append after repair: %v", got)
+	}
+}
+
+func TestTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	appendTs(t, s, "t", seq(5)...)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	paths := segmentFiles(t, dir, "t")
+	before, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var batch []*proto.DBTuple
+	for ts := int64(6); ts <= 15; ts++ {
+		batch = append(batch, &proto.DBTuple{Ts: ts, Data: []byte(fmt.Sprintf("row %d", ts))})
+	}
+	s = openStore(t, dir)
+	if err := s.Append("t", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every record of the batch has the same size
+	record := (int64(len(buf)) - before.Size()) / int64(len(batch))
+
+	// the crash cut the batch at a record boundary or in the middle of a record
+	for _, cut := range []int64{0, 3} {
+		t.Run(fmt.Sprintf("cut=%d", cut), func(t *testing.T) {
+			if err := os.WriteFile(paths[0], buf[:before.Size()+5*record-cut], 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := openStore(t, dir)
+			defer s.Close()
+			if got := scanTs(t, s, "t", 0, 100, false); !reflect.DeepEqual(got, seq(5)) {
+				t.Fatalf("expected the whole batch to be dropped, got %v", got)
+			}
+			stats, err := s.Stats("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.RowCount != 5 || stats.NewestTS != 5 {
+				t.Fatalf("unexpected stats %v", stats)
+			}
+
+			// the retried batch is stored once
+			if err := s.Append("t", batch); err != nil {
+				t.Fatal(err)
+			}
+			if got := scanTs(t, s, "t", 0, 100, false); !reflect.DeepEqual(got, seq(15)) {
+				t.Fatalf("after the retry got %v", got)
+			}
+		})
+	}
+}
+
+func TestReopenKeepsStats(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+300))
+	appendTs(t, s, "a", 5, 3, 9, 1, 7)
+	appendTs(t, s, "b", seq(40)...)
+	want := map[string]*proto.TableStatTuple{}
+	for _, name := range []string{"a", "b"} {
+		stats, err := s.Stats(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[name] = stats
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	defer s.Close()
+	tables, err := s.Tables()
+	if err != nil || len(tables) != 2 || tables[0] != "a" || tables[1] != "b" {
+		t.Fatalf("tables %v, %v", tables, err)
+	}
+	for name, w := range want {
+		got, err := s.Stats(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.RowCount != w.RowCount || got.OldestTS != w.OldestTS || got.NewestTS != w.NewestTS {
+			t.Fatalf("%s: stats %v after reopen, %v before", name, got, w)
+		}
+	}
+	if got := scanTs(t, s, "b", 0, 100, false); len(got) != 40 {
+		t.Fatalf("expected 40 rows, got %d", len(got))
+	}
+}
+
+func TestRolloverBySize(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+100))
+	defer s.Close()
+	appendTs(t, s, "t", seq(30)...)
+
+	paths := segmentFiles(t, dir, "t")
+	if len(paths) < 3 {
+		t.Fatalf("expected the table to roll over, got %d segments", len(paths))
+	}
+	for _, path := range paths[:len(paths)-1] {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a segment is only rolled over once it is full, so it ends at most one append past the limit
+		if info.Size() < headerSize+100 || info.Size() > headerSize+200 {
+			t.Fatalf("%s has %d bytes", path, info.Size())
+		}
+	}
+	if got := scanTs(t, s, "t", 0, 100, false); len(got) != 30 {
+		t.Fatalf("expected 30 rows, got %d", len(got))
+	}
+}
+
+func TestRolloverByAge(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentAge(20*time.Millisecond))
+	defer s.Close()
+
+	appendTs(t, s, "t", 1, 2)
+	time.Sleep(30 * time.Millisecond)
+	appendTs(t, s, "t", 3)
+
+	if paths := segmentFiles(t, dir, "t"); len(paths) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(paths))
+	}
+	if got := scanTs(t, s, "t", 0, 100, false); len(got) != 3 {
+		t.Fatalf("expected 3 rows, got %v", got)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+100))
+	appendTs(t, s, "t", seq(20)...)
+	appendTs(t, s, "keep", 1)
+
+	if err := s.Purge("t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stats("t"); err != server.ErrTableNotFound {
+		t.Fatalf("stats of a purged table: %v", err)
+	}
+	if err := s.Purge("t"); err != server.ErrTableNotFound {
+		t.Fatalf("purging twice: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%x", "t"))); !os.IsNotExist(err) {
+		t.Fatalf("table directory still exists: %v", err)
+	}
+
+	// a purged table can be created again from scratch
+	appendTs(t, s, "t", 100)
+	if got := scanTs(t, s, "t", 0, 1000, false); len(got) != 1 || got[0] != 100 {
+		t.Fatalf("rows after purge: %v", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	defer s.Close()
+	if tables, _ := s.Tables(); len(tables) != 2 {
+		t.Fatalf("tables after reopen: %v", tables)
+	}
+	if got := scanTs(t, s, "t", 0, 1000, false); len(got) != 1 {
+		t.Fatalf("rows after reopen: %v", got)
+	}
+}
+
+func TestLongTableNames(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	long := strings.Repeat("x", 300)
+	names := []string{strings.Repeat("a", maxDirName/2), strings.Repeat("b", maxDirName/2+1), long}
+	for i, name := range names {
+		appendTs(t, s, name, int64(i+1))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	defer s.Close()
+	tables, err := s.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tables, names) {
+		t.Fatalf("tables after reopen: %d names, want %d", len(tables), len(names))
+	}
+	for i, name := range names {
+		if got := scanTs(t, s, name, 0, 10, false); !reflect.DeepEqual(got, []int64{int64(i + 1)}) {
+			t.Fatalf("table %d: got %v", i, got)
+		}
+	}
+
+	if err := s.Purge(long); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tableDir(dir, long)); !os.IsNotExist(err) {
+		t.Fatalf("table directory still exists: %v", err)
+	}
+}
+
+func TestScanOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+150))
+	defer s.Close()
+
+	// segments overlap in time and hold rows out of order and with ties
+	ts := []int64{50, 10, 30, 30, 70, 20, 90, 10, 60, 40, 80, 30, 5, 100, 55, 55, 1, 95}
+	appendTs(t, s, "t", ts...)
+
+	check := func(start, stop int64, descending bool) {
+		t.Helper()
+		var want []int64
+		for _, v := range ts {
+			if v >= start && v <= stop {
+				want = append(want, v)
+			}
+		}
+		sort.Slice(want, func(i, j int) bool {
+			if descending {
+				return want[i] > want[j]
+			}
+			return want[i] < want[j]
+		})
+
+		got := scanTs(t, s, "t", start, stop, descending)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("scan %d to %d descending=%v: got %v, want %v", start, stop, descending, got, want)
+		}
+	}
+	for _, descending := range []bool{false, true} {
+		check(0, 1000, descending)
+		check(30, 60, descending)
+		check(55, 55, descending)
+		check(101, 200, descending)
+	}
+
+	// rows with the same timestamp keep the order they were appended in
+	for _, dat := range []string{"first", "second", "third"} {
+		if err := s.Append("t", []*proto.DBTuple{{Ts: 500, Data: []byte(dat)}, {Ts: 400}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	it, err := s.Scan("t", 500, 500, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var data []string
+	for it.Next() {
+		data = append(data, string(it.Row().Data))
+	}
+	if fmt.Sprint(data) != "[first second third]" {
+		t.Fatalf("rows at 500 in order %v", data)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		if s, err := Open(t.TempDir(), WithSyncPolicy(SyncInterval), WithSyncInterval(d)); err == nil {
+			s.Close()
+			t.Fatalf("open with a sync interval of %s succeeded", d)
+		}
+	}
+
+	s := openStore(t, t.TempDir(), WithSyncPolicy(SyncInterval), WithSyncInterval(time.Millisecond))
+	appendTs(t, s, "t", 1, 2, 3)
+	time.Sleep(5 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanMerge(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+8*indexInterval))
+
+	// ordered rows with runs of ties and a few late ones in the middle, over several
+	// segments that each hold several index blocks
+	rnd := rand.New(rand.NewSource(1))
+	var rows []*proto.DBTuple
+	ts := int64(0)
+	for i := 0; i < 3000; i++ {
+		v := ts
+		switch r := rnd.Intn(100); {
+		case r < 3 && i >= 1200 && i < 1500:
+			v = rnd.Int63n(ts + 1)
+		case r < 40:
+		default:
+			ts += rnd.Int63n(3) + 1
+			v = ts
+		}
+		rows = append(rows, &proto.DBTuple{Ts: v, Data: []byte(fmt.Sprintf("%060d", i))})
+	}
+	for i := 0; i < len(rows); i += 7 {
+		end := i + 7
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := s.Append("t", rows[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	segs := s.tables["t"].segments
+	s.mu.Unlock()
+	sorted := 0
+	for _, seg := range segs {
+		if seg.sorted {
+			sorted++
+		}
+	}
+	if len(segs) < 4 || sorted == 0 || sorted == len(segs) {
+		t.Fatalf("rows span %d segments, %d of them sorted", len(segs), sorted)
+	}
+
+	check := func(s *Store, start, stop int64, descending bool) {
+		t.Helper()
+		var want []string
+		for _, row := range rows {
+			if row.Ts >= start && row.Ts <= stop {
+				want = append(want, string(row.Data))
+			}
+		}
+		sort.SliceStable(want, func(i, j int) bool { return rowTs(rows, want[i]) < rowTs(rows, want[j]) })
+		if descending {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+
+		it, err := s.Scan("t", start, stop, descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		var got []string
+		for it.Next() {
+			got = append(got, string(it.Row().Data))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("scan %d to %d descending=%v: got %d rows, want %d, or a different order", start, stop, descending, len(got), len(want))
+		}
+	}
+
+	ranges := [][2]int64{{0, ts}, {-1, -1}, {ts + 1, ts + 10}}
+	for i := 0; i < 30; i++ {
+		a, b := rnd.Int63n(ts+1), rnd.Int63n(ts+1)
+		if a > b {
+			a, b = b, a
+		}
+		ranges = append(ranges, [2]int64{a, b}, [2]int64{a, a})
+	}
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s = openStore(t, dir)
+		}
+		for _, r := range ranges {
+			check(s, r[0], r[1], false)
+			check(s, r[0], r[1], true)
+		}
+	}
+	s.Close()
+}
+
+// rowTs returns the timestamp of the row holding data, the data is the index of the row
+func rowTs(rows []*proto.DBTuple, data string) int64 {
+	i, _ := strconv.Atoi(data)
+	return rows[i].Ts
+}
+
+func TestScanDuringPurge(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+200))
+	defer s.Close()
+	appendTs(t, s, "t", seq(50)...)
+	if n := len(segmentFiles(t, dir, "t")); n < 5 {
+		t.Fatalf("rows span %d segments", n)
+	}
+
+	for _, descending := range []bool{false, true} {
+		it, err := s.Scan("t", 0, 100, descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !it.Next() {
+			t.Fatalf("scan is empty: %v", it.Err())
+		}
+		n := 1
+
+		// the scan sees the table as it was when it started
+		if err := s.Purge("t"); err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("scan after purge: %v", err)
+		}
+		if trash, _ := filepath.Glob(filepath.Join(dir, purgedPrefix+"*")); len(trash) != 1 {
+			t.Fatalf("got %d purged directories while the scan runs, want 1", len(trash))
+		}
+		it.Close()
+		if n != 50 {
+			t.Fatalf("scan after purge got %d rows, want 50", n)
+		}
+		if trash, _ := filepath.Glob(filepath.Join(dir, purgedPrefix+"*")); len(trash) != 0 {
+			t.Fatalf("purged directories %v outlived the scan", trash)
+		}
+		appendTs(t, s, "t", seq(50)...)
+	}
+}
+
+// openFiles counts the segment files a scan holds open
+func openFiles(it server.Iterator) int {
+	n := 0
+	for _, cursors := range [][]*cursor{it.(*iterator).pending, it.(*iterator).merge.cursors} {
+		for _, c := range cursors {
+			if c.file != nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestScanManySegments(t *testing.T) {
+	const rows = 3000
+
+	dir := t.TempDir()
+	// every append fills a segment, so each row gets its own
+	s := openStore(t, dir, WithSyncPolicy(SyncNever), WithMaxSegmentSize(headerSize+1))
+	defer s.Close()
+	appendTs(t, s, "t", seq(rows)...)
+	if n := len(segmentFiles(t, dir, "t")); n != rows {
+		t.Fatalf("rows span %d segments, want %d", n, rows)
+	}
+
+	for _, descending := range []bool{false, true} {
+		it, err := s.Scan("t", 0, rows, descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := openFiles(it); n != 0 {
+			t.Fatalf("scan opened %d files before reading", n)
+		}
+		n := 0
+		for it.Next() {
+			n++
+			if open := openFiles(it); open > 1 {
+				t.Fatalf("descending=%v: %d files open at row %d", descending, open, n)
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("descending=%v: %v", descending, err)
+		}
+		it.Close()
+		if n != rows {
+			t.Fatalf("descending=%v: got %d rows, want %d", descending, n, rows)
+		}
+	}
+
+	// a purge waits for the scan to end before removing the files it has yet to read
+	it, err := s.Scan("t", 0, rows, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Purge("t"); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err != nil || n != rows {
+		t.Fatalf("scan across a purge got %d rows, %v", n, err)
+	}
+	it.Close()
+}
+
+func TestOpenRemovesPurgedTables(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	appendTs(t, s, "t", seq(10)...)
+
+	// the store stops while a scan still holds the purged table
+	it, err := s.Scan("t", 0, 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Purge("t"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openStore(t, dir)
+	defer s.Close()
+	if trash, _ := filepath.Glob(filepath.Join(dir, purgedPrefix+"*")); len(trash) != 0 {
+		t.Fatalf("purged directories %v survived a reopen", trash)
+	}
+	if tables, _ := s.Tables(); len(tables) != 0 {
+		t.Fatalf("tables after reopen: %v", tables)
+	}
+	it.Close()
+}
+
+func appendKey(key string, at time.Time) server.AppendKey {
+	return server.AppendKey{Method: "BatchAppend", Key: key, Sum: [32]byte{1, 2, 3}, At: at.Round(0)}
+}
+
+func TestKeysSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	// small segments, so keys land in sealed segments too
+	s := openStore(t, dir, WithMaxSegmentSize(headerSize+20000))
+
+	var want []int64
+	var wantKeys []server.AppendKey
+	for i := 0; i < 100; i++ {
+		// the last row of a batch is larger than indexInterval, so the first row of the next
+		// gets an index entry and the index block before it ends with that batch's key record
+		var rows []*proto.DBTuple
+		for j := 0; j < 10; j++ {
+			ts := int64(i*10 + j + 1)
+			size := 50
+			if j == 9 {
+				size = indexInterval + 100
+			}
+			rows = append(rows, &proto.DBTuple{Ts: ts, Data: make([]byte, size)})
+			want = append(want, ts)
+		}
+		key := appendKey(fmt.Sprintf("k%d", i), now)
+		if err := s.AppendKeyed("t", rows, key); err != nil {
+			t.Fatal(err)
+		}
+		wantKeys = append(wantKeys, key)
+	}
+	if err := s.AppendKeyed("u", batchTs(1), appendKey("expired", now.Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	appendTs(t, s, "u", 2)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	defer s.Close()
+	keys := s.Keys()
+	if !reflect.DeepEqual(keys, map[string][]server.AppendKey{"t": wantKeys}) {
+		t.Fatalf("got keys %v, want the %d keys of t", keys, len(wantKeys))
+	}
+
+	// the key records are skipped by scans
+	if got := scanTs(t, s, "t", 0, 10000, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ascending scan got %d rows, want %d", len(got), len(want))
+	}
+	got := scanTs(t, s, "t", 0, 10000, true)
+	for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+		got[i], got[j] = got[j], got[i]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("descending scan got %d rows, want %d", len(got), len(want))
+	}
+	if got := scanTs(t, s, "u", 0, 10, false); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+
+	stats, err := s.Stats("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RowCount != int64(len(want)) {
+		t.Fatalf("got %d rows, want %d", stats.RowCount, len(want))
+	}
+}
+
+func TestTornKeyedBatch(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	appendTs(t, s, "t", 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	paths := segmentFiles(t, dir, "t")
+	before, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir)
+	if err := s.AppendKeyed("t", batchTs(2, 3), appendKey("k", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRecord := recordHeaderSize + keyHeaderSize + len("BatchAppend") + len("k")
+
+	// the crash left the key record and part of the rows, or the key record alone
+	for _, cut := range []int{keyRecord + 3, keyRecord} {
+		t.Run(fmt.Sprintf("kept=%d", cut), func(t *testing.T) {
+			if err := os.WriteFile(paths[0], buf[:int(before.Size())+cut], 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := openStore(t, dir)
+			defer s.Close()
+			if keys := s.Keys(); len(keys) != 0 {
+				t.Fatalf("got keys %v of a torn batch", keys)
+			}
+			if got := scanTs(t, s, "t", 0, 10, false); !reflect.DeepEqual(got, []int64{1}) {
+				t.Fatalf("got %v, want the batch dropped", got)
+			}
+			info, err := os.Stat(paths[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != before.Size() {
+				t.Fatalf("segment has %d bytes, want it cut back to %d", info.Size(), before.Size())
+			}
+		})
+	}
+}
+
+func batchTs(ts ...int64) []*proto.DBTuple {
+	rows := make([]*proto.DBTuple, len(ts))
+	for i, v := range ts {
+		rows[i] = &proto.DBTuple{Ts: v, Data: []byte(fmt.Sprintf("row %d", v))}
+	}
+	return rows
+}
@@ -0,0 +1,488 @@
+// Package server is a reference implementation of the append-only DBService
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultChunkSize   = 1000
+	maxChunkSize       = 10000
+	maxChunkBytes      = 1 << 20
+	streamBatchSize    = 1000
+	streamBatchBytes   = 1 << 20
+	defaultPageSize    = 1000
+	maxPageSize        = 10000
+	defaultMaxRowSize  = 1 << 20
+	defaultDedupWindow = 5 * time.Minute
+)
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithDedupeWindow sets how long the idempotency keys of appends are remembered
+// A zero window disables deduplication
+func WithDedupeWindow(d time.Duration) Option {
+	return func(s *Server) {
+		s.dedupe = newDedupe(d)
+	}
+}
+
+// WithMaxRowSize rejects rows whose data is larger than n bytes
+func WithMaxRowSize(n int) Option {
+	return func(s *Server) {
+		s.maxRowSize = n
+	}
+}
+
+// Server implements proto.DBServiceServer on top of a Storage
+type Server struct {
+	proto.UnimplementedDBServiceServer
+
+	store      Storage
+	hub        *hub
+	dedupe     *dedupe
+	maxRowSize int
+}
+
+// New creates a server storing its tables in store
+func New(store Storage, opts ...Option) *Server {
+	s := &Server{
+		store:      store,
+		hub:        newHub(),
+		dedupe:     newDedupe(defaultDedupWindow),
+		maxRowSize: defaultMaxRowSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if keyed, ok := store.(KeyedStorage); ok {
+		s.dedupe.restore(keyed.Keys())
+	}
+	return s
+}
+
+// Append implements proto.DBServiceServer
+func (s *Server) Append(ctx context.Context, req *proto.AppendRequest) (*proto.Empty, error) {
+	if err := validTable(req.Table); err != nil {
+		return nil, err
+	}
+	if msg := s.validRow(req.Data); msg != "" {
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	rows := []*proto.DBTuple{req.Data}
+	err := s.dedupe.do("Append", req.Table, req.IdempotencyKey, rows, func(key AppendKey) error {
+		return s.append(req.Table, rows, key)
+	})
+	if errors.Is(err, errKeyReused) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, storageErr(req.Table, err)
+	}
+	return &proto.Empty{}, nil
+}
+
+// BatchAppend implements proto.DBServiceServer
+func (s *Server) BatchAppend(ctx context.Context, req *proto.BatchAppendRequest) (*proto.BatchAppendResponse, error) {
+	if err := validTable(req.Table); err != nil {
+		return nil, err
+	}
+
+	var resp proto.BatchAppendResponse
+	for i, row := range req.Data {
+		if msg := s.validRow(row); msg != "" {
+			resp.Errors = append(resp.Errors, &proto.RowError{Index: int32(i), Message: msg})
+		}
+	}
+	if len(resp.Errors) > 0 {
+		return &resp, nil
+	}
+
+	err := s.dedupe.do("BatchAppend", req.Table, req.IdempotencyKey, req.Data, func(key AppendKey) error {
+		return s.append(req.Table, req.Data, key)
+	})
+	if errors.Is(err, errKeyReused) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, storageErr(req.Table, err)
+	}
+
+	resp.Appended = int64(len(req.Data))
+	return &resp, nil
+}
+
+// AppendStream implements proto.DBServiceServer
+// Rows are stored in batches of up to streamBatchSize rows or streamBatchBytes of data,
+// the rows received before an error are stored before it is returned and counted in the trailers
+func (s *Server) AppendStream(stream proto.DBService_AppendStreamServer) error {
+	var resp proto.AppendStreamResponse
+	var table string
+	var rows []*proto.DBTuple
+	size := 0
+
+	fail := func(err error) error {
+		stream.SetTrailer(metadata.Pairs(
+			proto.AppendStreamCountTrailer, strconv.FormatInt(resp.Count, 10),
+			proto.AppendStreamLastTsTrailer, strconv.FormatInt(resp.LastTs, 10),
+		))
+		return err
+	}
+
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := s.append(table, rows, AppendKey{}); err != nil {
+			return storageErr(table, err)
+		}
+		resp.Count += int64(len(rows))
+		resp.LastTs = rows[len(rows)-1].Ts
+		rows, size = rows[:0], 0
+		return nil
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+			return stream.SendAndClose(&resp)
+		}
+		if err == nil {
+			err = validTable(req.Table)
+		}
+		if err == nil {
+			if msg := s.validRow(req.Data); msg != "" {
+				err = status.Errorf(codes.InvalidArgument, "row %d: %s", resp.Count+int64(len(rows)), msg)
+			}
+		}
+		if err != nil {
+			if ferr := flush(); ferr != nil {
+				return fail(ferr)
+			}
+			return fail(err)
+		}
+
+		if req.Table != table {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+			table = req.Table
+		}
+		rows = append(rows, req.Data)
+		size += len(req.Data.Data)
+		if len(rows) >= streamBatchSize || size >= streamBatchBytes {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+}
+
+// append stores the rows and hands them to the table's subscribers
+// A storage that keeps idempotency keys stores key with the rows when it is set
+func (s *Server) append(table string, rows []*proto.DBTuple, key AppendKey) error {
+	t := s.hub.topic(table)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	if keyed, ok := s.store.(KeyedStorage); ok && key.Key != "" {
+		err = keyed.AppendKeyed(table, rows, key)
+	} else {
+		err = s.store.Append(table, rows)
+	}
+	if err != nil {
+		return err
+	}
+	t.publish(rows)
+	return nil
+}
+
+// Query implements proto.DBServiceServer
+func (s *Server) Query(ctx context.Context, req *proto.QueryRequest) (*proto.QueryResponse, error) {
+	var resp proto.QueryResponse
+	token, err := s.query(req, func(row *proto.DBTuple) error {
+		resp.Data = append(resp.Data, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.NextPageToken = token
+	return &resp, nil
+}
+
+// StreamQuery implements proto.DBServiceServer
+// A chunk is sent once it holds chunkSize rows or more than maxChunkBytes of data,
+// so large rows stay under the client's message size limit
+func (s *Server) StreamQuery(req *proto.QueryRequest, stream proto.DBService_StreamQueryServer) error {
+	chunkSize := int(req.ChunkSize)
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if chunkSize > maxChunkSize {
+		chunkSize = maxChunkSize
+	}
+
+	chunk := make([]*proto.DBTuple, 0, chunkSize)
+	size := 0
+	_, err := s.query(req, func(row *proto.DBTuple) error {
+		chunk = append(chunk, row)
+		size += len(row.Data)
+		if len(chunk) < chunkSize && size <= maxChunkBytes {
+			return nil
+		}
+		err := stream.Send(&proto.QueryResponse{Data: chunk})
+		chunk = make([]*proto.DBTuple, 0, chunkSize)
+		size = 0
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(chunk) > 0 {
+		return stream.Send(&proto.QueryResponse{Data: chunk})
+	}
+	return nil
+}
+
+// query passes the rows selected by req to fn, resuming from its page token
+// When the limit stops it early it returns the token of the next page
+func (s *Server) query(req *proto.QueryRequest, fn func(*proto.DBTuple) error) (string, error) {
+	if err := validTable(req.Table); err != nil {
+		return "", err
+	}
+
+	start, stop := req.Start, req.Stop
+
+	// last and run track the newest timestamp passed to fn and how many rows
+	// with it were returned so far, on this page or the previous ones
+	var last, run, skip int64
+	started := false
+	if req.PageToken != "" {
+		ts, n, err := decodePageToken(req.PageToken)
+		if err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+		if req.Descending {
+			stop = ts
+		} else {
+			start = ts
+		}
+		last, run, skip, started = ts, n, n, true
+	}
+	if start > stop {
+		return "", nil
+	}
+
+	it, err := s.store.Scan(req.Table, start, stop, req.Descending)
+	if err != nil {
+		return "", storageErr(req.Table, err)
+	}
+	defer it.Close()
+
+	returned := 0
+	for it.Next() {
+		row := it.Row()
+		if skip > 0 && row.Ts == last {
+			skip--
+			continue
+		}
+		skip = 0
+
+		if req.Limit > 0 && returned == int(req.Limit) {
+			return encodePageToken(last, run), nil
+		}
+
+		if started && row.Ts == last {
+			run++
+		} else {
+			last, run, started = row.Ts, 1, true
+		}
+
+		if err := fn(row); err != nil {
+			return "", err
+		}
+		returned++
+	}
+
+	if err := it.Err(); err != nil {
+		return "", storageErr(req.Table, err)
+	}
+	return "", nil
+}
+
+// Stats implements proto.DBServiceServer
+func (s *Server) Stats(ctx context.Context, req *proto.TableRequest) (*proto.TableStatTuple, error) {
+	if err := validTable(req.Table); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.store.Stats(req.Table)
+	if err != nil {
+		return nil, storageErr(req.Table, err)
+	}
+	return stats, nil
+}
+
+// ListTables implements proto.DBServiceServer
+// A zero page size returns defaultPageSize tables, larger sizes are capped to maxPageSize
+func (s *Server) ListTables(ctx context.Context, req *proto.ListTablesRequest) (*proto.ListTablesResponse, error) {
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	tables, err := s.store.Tables()
+	if err != nil {
+		return nil, storageErr("", err)
+	}
+
+	after := ""
+	if req.PageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, errBadToken.Error())
+		}
+		after = string(b)
+	}
+
+	// tables are sorted so the first candidate is the first name past both the prefix and the token
+	from := req.Prefix
+	if after >= from {
+		from = after
+	}
+	i := sort.SearchStrings(tables, from)
+	if i < len(tables) && tables[i] == after {
+		i++
+	}
+
+	var resp proto.ListTablesResponse
+	for ; i < len(tables) && strings.HasPrefix(tables[i], req.Prefix); i++ {
+		if len(resp.Tables) == pageSize {
+			resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(resp.Tables[len(resp.Tables)-1]))
+			break
+		}
+		resp.Tables = append(resp.Tables, tables[i])
+	}
+	return &resp, nil
+}
+
+// Purge implements proto.DBServiceServer
+func (s *Server) Purge(ctx context.Context, req *proto.TableRequest) (*proto.Empty, error) {
+	if err := validTable(req.Table); err != nil {
+		return nil, err
+	}
+
+	t := s.hub.topic(req.Table)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := s.store.Purge(req.Table); err != nil {
+		return nil, storageErr(req.Table, err)
+	}
+	s.dedupe.forget(req.Table)
+	return &proto.Empty{}, nil
+}
+
+// Subscribe implements proto.DBServiceServer
+// A subscriber that falls too far behind is disconnected with Unavailable and should resubscribe
+func (s *Server) Subscribe(req *proto.SubscribeRequest, stream proto.DBService_SubscribeServer) error {
+	if err := validTable(req.Table); err != nil {
+		return err
+	}
+
+	t := s.hub.topic(req.Table)
+	t.mu.Lock()
+	sub := t.subscribe(req.FromTs)
+	it, err := s.store.Scan(req.Table, req.FromTs, math.MaxInt64, false)
+	t.mu.Unlock()
+	defer t.unsubscribe(sub)
+
+	// a table that doesn't exist yet has nothing to replay
+	if err != nil && !errors.Is(err, ErrTableNotFound) {
+		return storageErr(req.Table, err)
+	}
+	if err == nil {
+		err = replay(it, stream)
+		if err != nil {
+			return storageErr(req.Table, err)
+		}
+	}
+
+	for {
+		select {
+		case row := <-sub.rows:
+			if err := stream.Send(row); err != nil {
+				return err
+			}
+		case <-sub.dropped:
+			return status.Error(codes.Unavailable, "subscriber fell behind")
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func replay(it Iterator, stream proto.DBService_SubscribeServer) error {
+	defer it.Close()
+	for it.Next() {
+		if err := stream.Send(it.Row()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func validTable(table string) error {
+	if table == "" {
+		return status.Error(codes.InvalidArgument, "table name is empty")
+	}
+	return nil
+}
+
+// validRow returns why a row can't be stored, or an empty string
+func (s *Server) validRow(row *proto.DBTuple) string {
+	if row == nil {
+		return "row is empty"
+	}
+	if s.maxRowSize > 0 && len(row.Data) > s.maxRowSize {
+		return fmt.Sprintf("row data is %d bytes, the limit is %d", len(row.Data), s.maxRowSize)
+	}
+	return ""
+}
+
+// storageErr converts an error to a grpc status, status errors are returned as is
+func storageErr(table string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, ErrTableNotFound) {
+		return status.Errorf(codes.NotFound, "table %s not found", table)
+	}
+	return status.Error(codes.Internal, err.Error())
+}
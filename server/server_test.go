@@ -0,0 +1,425 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"github.com/r-coffee/db-append-only-sdk/server"
+	"github.com/r-coffee/db-append-only-sdk/server/segment"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func start(t *testing.T, opts ...server.Option) (*dbsdktest.Server, proto.DBServiceClient) {
+	t.Helper()
+	srv := dbsdktest.NewServer(opts...)
+	t.Cleanup(srv.Close)
+
+	conn, err := srv.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, proto.NewDBServiceClient(conn)
+}
+
+func batch(ts ...int64) []*proto.DBTuple {
+	rows := make([]*proto.DBTuple, len(ts))
+	for i, v := range ts {
+		rows[i] = &proto.DBTuple{Ts: v, Data: []byte(fmt.Sprintf("row %d", v))}
+	}
+	return rows
+}
+
+func appendRows(t *testing.T, client proto.DBServiceClient, table string, rows []*proto.DBTuple) {
+	t.Helper()
+	if _, err := client.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: table, Data: rows}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+}
+
+func TestListTables(t *testing.T) {
+	_, client := start(t)
+	for _, name := range []string{"c", "ab", "a", "ba", "abc", "b"} {
+		appendRows(t, client, name, batch(1))
+	}
+
+	tests := []struct {
+		prefix   string
+		pageSize int32
+		want     []string
+		pages    int
+	}{
+		{pageSize: 0, want: []string{"a", "ab", "abc", "b", "ba", "c"}, pages: 1},
+		{pageSize: 2, want: []string{"a", "ab", "abc", "b", "ba", "c"}, pages: 3},
+		{pageSize: 4, want: []string{"a", "ab", "abc", "b", "ba", "c"}, pages: 2},
+		{prefix: "a", pageSize: 2, want: []string{"a", "ab", "abc"}, pages: 2},
+		{prefix: "a", pageSize: 3, want: []string{"a", "ab", "abc"}, pages: 1},
+		{prefix: "ab", pageSize: 1, want: []string{"ab", "abc"}, pages: 2},
+		{prefix: "b", pageSize: 0, want: []string{"b", "ba"}, pages: 1},
+		{prefix: "bb", pageSize: 1, pages: 1},
+		{prefix: "z", pageSize: 0, pages: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("prefix=%q/size=%d", tt.prefix, tt.pageSize), func(t *testing.T) {
+			var got []string
+			token := ""
+			pages := 0
+			for {
+				resp, err := client.ListTables(context.Background(), &proto.ListTablesRequest{Prefix: tt.prefix, PageSize: tt.pageSize, PageToken: token})
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				pages++
+				if tt.pageSize > 0 && len(resp.Tables) > int(tt.pageSize) {
+					t.Fatalf("page %d has %d tables", pages, len(resp.Tables))
+				}
+				got = append(got, resp.Tables...)
+				if resp.NextPageToken == "" {
+					break
+				}
+				token = resp.NextPageToken
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if pages != tt.pages {
+				t.Fatalf("got %d pages, want %d", pages, tt.pages)
+			}
+		})
+	}
+
+	_, err := client.ListTables(context.Background(), &proto.ListTablesRequest{PageToken: "!"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("bad token: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	type request struct {
+		method string
+		table  string
+		key    string
+		ts     int64
+		code   codes.Code
+	}
+	tests := []struct {
+		name   string
+		window time.Duration
+		purge  bool
+		reqs   []request
+		// want is the number of rows of each table
+		want map[string]int
+	}{
+		{
+			name: "retry",
+			reqs: []request{{table: "t", key: "k", ts: 1}, {table: "t", key: "k", ts: 1}},
+			want: map[string]int{"t": 1},
+		},
+		{
+			name: "different rows",
+			reqs: []request{{table: "t", key: "k", ts: 1}, {table: "t", key: "k", ts: 2, code: codes.InvalidArgument}},
+			want: map[string]int{"t": 1},
+		},
+		{
+			name: "different tables",
+			reqs: []request{{table: "t", key: "k", ts: 1}, {table: "u", key: "k", ts: 1}},
+			want: map[string]int{"t": 1, "u": 1},
+		},
+		{
+			name: "different methods",
+			reqs: []request{{table: "t", key: "k", ts: 1}, {method: "Append", table: "t", key: "k", ts: 1}},
+			want: map[string]int{"t": 2},
+		},
+		{
+			name: "no key",
+			reqs: []request{{table: "t", ts: 1}, {table: "t", ts: 1}},
+			want: map[string]int{"t": 2},
+		},
+		{
+			name:   "disabled",
+			window: -1,
+			reqs:   []request{{table: "t", key: "k", ts: 1}, {table: "t", key: "k", ts: 1}},
+			want:   map[string]int{"t": 2},
+		},
+		{
+			name:  "purged",
+			purge: true,
+			reqs:  []request{{table: "t", key: "k", ts: 1}, {table: "t", key: "k", ts: 1}},
+			want:  map[string]int{"t": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []server.Option
+			if tt.window != 0 {
+				opts = append(opts, server.WithDedupeWindow(tt.window))
+			}
+			srv, client := start(t, opts...)
+
+			for i, req := range tt.reqs {
+				if tt.purge && i == len(tt.reqs)-1 {
+					if _, err := client.Purge(context.Background(), &proto.TableRequest{Table: req.table}); err != nil {
+						t.Fatalf("purge: %v", err)
+					}
+				}
+
+				var err error
+				rows := batch(req.ts)
+				if req.method == "Append" {
+					_, err = client.Append(context.Background(), &proto.AppendRequest{Table: req.table, Data: rows[0], IdempotencyKey: req.key})
+				} else {
+					_, err = client.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: req.table, Data: rows, IdempotencyKey: req.key})
+				}
+				if status.Code(err) != req.code {
+					t.Fatalf("request %d: got %v, want %s", i, err, req.code)
+				}
+			}
+
+			for table, want := range tt.want {
+				if n := len(srv.Rows(table)); n != want {
+					t.Fatalf("table %s has %d rows, want %d", table, n, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupeInFlight(t *testing.T) {
+	srv, client := start(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: "t", Data: batch(1, 2, 3), IdempotencyKey: "k"})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if n := len(srv.Rows("t")); n != 3 {
+		t.Fatalf("got %d rows, want 3", n)
+	}
+}
+
+func TestDedupeAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*segment.Store, *server.Server) {
+		store, err := segment.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store, server.New(store)
+	}
+
+	store, srv := open()
+	if _, err := srv.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: "t", Data: batch(1, 2, 3), IdempotencyKey: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Append(context.Background(), &proto.AppendRequest{Table: "t", Data: batch(4)[0], IdempotencyKey: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	// the server stops after storing the rows, before the client hears back
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, srv = open()
+	defer store.Close()
+	if _, err := srv.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: "t", Data: batch(1, 2, 3), IdempotencyKey: "k"}); err != nil {
+		t.Fatalf("batch retry: %v", err)
+	}
+	if _, err := srv.Append(context.Background(), &proto.AppendRequest{Table: "t", Data: batch(4)[0], IdempotencyKey: "k"}); err != nil {
+		t.Fatalf("append retry: %v", err)
+	}
+	if _, err := srv.BatchAppend(context.Background(), &proto.BatchAppendRequest{Table: "t", Data: batch(5), IdempotencyKey: "k"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("key reused for other rows: got %v, want InvalidArgument", err)
+	}
+
+	stats, err := store.Stats("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RowCount != 4 {
+		t.Fatalf("got %d rows, want the retries applied once", stats.RowCount)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	const replayed, live = 1000, 500
+
+	_, client := start(t)
+	for i := int64(0); i < replayed; i += 100 {
+		ts := make([]int64, 100)
+		for j := range ts {
+			ts[j] = i + int64(j) + 1
+		}
+		appendRows(t, client, "t", batch(ts...))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &proto.SubscribeRequest{Table: "t", FromTs: 101})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// rows appended while the subscriber replays are handed over live, each exactly once
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= live; i++ {
+			if _, err := client.BatchAppend(ctx, &proto.BatchAppendRequest{Table: "t", Data: batch(replayed + i)}); err != nil {
+				t.Errorf("append: %v", err)
+				return
+			}
+		}
+	}()
+
+	for want := int64(101); want <= replayed+live; want++ {
+		row, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		if row.Ts != want {
+			t.Fatalf("got ts %d, want %d", row.Ts, want)
+		}
+	}
+	<-done
+}
+
+func TestQueryPagesAcrossTies(t *testing.T) {
+	_, client := start(t)
+	var rows []*proto.DBTuple
+	for i, ts := range []int64{1, 2, 2, 2, 3, 3, 4, 5, 5, 5, 5, 6} {
+		rows = append(rows, &proto.DBTuple{Ts: ts, Data: []byte(fmt.Sprintf("row %d", i))})
+	}
+	appendRows(t, client, "t", rows)
+
+	query := func(req *proto.QueryRequest) []string {
+		t.Helper()
+		var data []string
+		for pages := 0; ; pages++ {
+			if pages > len(rows) {
+				t.Fatal("paging did not end")
+			}
+			resp, err := client.Query(context.Background(), req)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if req.Limit > 0 && len(resp.Data) > int(req.Limit) {
+				t.Fatalf("page has %d rows, limit is %d", len(resp.Data), req.Limit)
+			}
+			for _, row := range resp.Data {
+				data = append(data, string(row.Data))
+			}
+			if resp.NextPageToken == "" {
+				return data
+			}
+			req.PageToken = resp.NextPageToken
+		}
+	}
+
+	for _, r := range []struct{ start, stop int64 }{{1, 6}, {2, 5}, {5, 5}} {
+		for _, descending := range []bool{false, true} {
+			want := query(&proto.QueryRequest{Table: "t", Start: r.start, Stop: r.stop, Descending: descending})
+			for limit := int32(1); limit <= int32(len(rows))+1; limit++ {
+				t.Run(fmt.Sprintf("%d-%d/descending=%v/limit=%d", r.start, r.stop, descending, limit), func(t *testing.T) {
+					got := query(&proto.QueryRequest{Table: "t", Start: r.start, Stop: r.stop, Descending: descending, Limit: limit})
+					if !reflect.DeepEqual(got, want) {
+						t.Fatalf("got %q, want %q", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestStreamQueryLargeRows(t *testing.T) {
+	const rows, size = 1000, 5000
+
+	_, client := start(t)
+	for i := int64(0); i < rows; i += 100 {
+		batch := make([]*proto.DBTuple, 100)
+		for j := range batch {
+			batch[j] = &proto.DBTuple{Ts: i + int64(j), Data: make([]byte, size)}
+		}
+		appendRows(t, client, "t", batch)
+	}
+
+	// a chunk of the default 1000 rows would pass the client's 4MB message limit
+	stream, err := client.StreamQuery(context.Background(), &proto.QueryRequest{Table: "t", Start: 0, Stop: rows})
+	if err != nil {
+		t.Fatalf("stream query: %v", err)
+	}
+	n := 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("recv after %d rows: %v", n, err)
+		}
+		n += len(resp.Data)
+	}
+	if n != rows {
+		t.Fatalf("got %d rows, want %d", n, rows)
+	}
+}
+
+func TestListTablesPageSizeLimits(t *testing.T) {
+	const tables = 10001
+
+	srv, client := start(t)
+	for i := 0; i < tables; i++ {
+		appendRows(t, client, fmt.Sprintf("t%05d", i), batch(1))
+	}
+
+	tests := []struct {
+		pageSize int32
+		pages    []int
+	}{
+		{pageSize: 0, pages: []int{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1}},
+		{pageSize: 20000, pages: []int{10000, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("size=%d", tt.pageSize), func(t *testing.T) {
+			var got []string
+			var pages []int
+			token := ""
+			for {
+				resp, err := client.ListTables(context.Background(), &proto.ListTablesRequest{PageSize: tt.pageSize, PageToken: token})
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				pages = append(pages, len(resp.Tables))
+				got = append(got, resp.Tables...)
+				if resp.NextPageToken == "" {
+					break
+				}
+				token = resp.NextPageToken
+			}
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Fatalf("got pages of %v tables, want %v", pages, tt.pages)
+			}
+			if !reflect.DeepEqual(got, srv.Tables()) {
+				t.Fatalf("listed %d tables, want %d", len(got), tables)
+			}
+		})
+	}
+}
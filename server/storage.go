@@ -0,0 +1,64 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// ErrTableNotFound is returned by a Storage for tables that don't exist
+var ErrTableNotFound = errors.New("table not found")
+
+// Storage persists the rows of the tables served by a Server
+// Implementations must be safe for concurrent use
+type Storage interface {
+	// Append adds the rows to a table, creating it if needed
+	// Either every row is stored or none are
+	Append(table string, rows []*proto.DBTuple) error
+
+	// Scan returns the rows of a table with start <= ts <= stop in timestamp order,
+	// rows sharing a timestamp in the order they were appended, descending reverses both
+	// The iterator sees the table as it was when Scan returned, later appends are not visited
+	Scan(table string, start, stop int64, descending bool) (Iterator, error)
+
+	// Stats returns the row count and the oldest and newest timestamps of a table
+	Stats(table string) (*proto.TableStatTuple, error)
+
+	// Tables returns the names of all the tables in name order
+	Tables() ([]string, error)
+
+	// Purge removes a table and all of its rows
+	Purge(table string) error
+}
+
+// Iterator walks the rows returned by Storage.Scan
+type Iterator interface {
+	Next() bool
+	Row() *proto.DBTuple
+	Err() error
+	Close() error
+}
+
+// AppendKey is the idempotency key of an append as the server's dedupe window remembers it
+type AppendKey struct {
+	// Method is the rpc of the append, Append or BatchAppend
+	Method string
+	Key    string
+	// Sum hashes the rows, a key that comes back with other rows is not a retry
+	Sum [sha256.Size]byte
+	At  time.Time
+}
+
+// KeyedStorage is a Storage that keeps the idempotency key of an append with its rows,
+// so a server restarted on it still applies the retries of appends it stored only once
+type KeyedStorage interface {
+	Storage
+
+	// AppendKeyed appends the rows like Append and stores key in the same write
+	AppendKeyed(table string, rows []*proto.DBTuple, key AppendKey) error
+
+	// Keys returns the keys of the appends stored shortly before the storage was opened, by table
+	Keys() map[string][]AppendKey
+}
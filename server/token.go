@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+var errBadToken = errors.New("malformed page token")
+
+// encodePageToken builds the token of a Query page that ended at ts
+// skip is how many rows with that timestamp were already returned
+func encodePageToken(ts, skip int64) string {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, ts)
+	n += binary.PutVarint(buf[n:], skip)
+	return base64.RawURLEncoding.EncodeToString(buf[:n])
+}
+
+func decodePageToken(token string) (int64, int64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, 0, errBadToken
+	}
+
+	ts, n := binary.Varint(buf)
+	if n <= 0 {
+		return 0, 0, errBadToken
+	}
+	skip, m := binary.Varint(buf[n:])
+	if m <= 0 || n+m != len(buf) || skip < 0 {
+		return 0, 0, errBadToken
+	}
+	return ts, skip, nil
+}
@@ -0,0 +1,67 @@
+package dbsdk
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+)
+
+// AppendStream sends rows to a table over a single client stream
+type AppendStream struct {
+	table  string
+	stream proto.DBService_AppendStreamClient
+	cancel context.CancelFunc
+}
+
+// AppendStream opens a stream of appends to the table
+// The stream lives as long as ctx, the client's request timeout does not apply
+func (s *AppendDbSDKClient) AppendStream(ctx context.Context, table string) (*AppendStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := s.stub.AppendStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, wrapErr("AppendStream", table, err)
+	}
+	return &AppendStream{table: table, stream: stream, cancel: cancel}, nil
+}
+
+// Send queues a row on the stream
+// If the server aborted the stream Send returns io.EOF and the cause is reported by CloseAndRecv
+func (a *AppendStream) Send(ts time.Time, dat []byte) error {
+	return a.stream.Send(&proto.AppendRequest{Table: a.table, Data: &proto.DBTuple{Ts: ts.UnixNano(), Data: dat}})
+}
+
+// CloseAndRecv finishes the stream and returns the number of rows the server accepted
+// and the timestamp of the last row it committed
+// When the server aborted the stream they count the rows stored before the error,
+// a server that does not report them gives 0 and the zero time
+func (a *AppendStream) CloseAndRecv() (int64, time.Time, error) {
+	defer a.cancel()
+
+	resp, err := a.stream.CloseAndRecv()
+	if err != nil {
+		count, lastTs := a.committed()
+		return count, lastTs, wrapErr("AppendStream", a.table, err)
+	}
+	return resp.Count, time.Unix(0, resp.LastTs), nil
+}
+
+// committed reads the rows stored before a failure from the trailers of the stream
+func (a *AppendStream) committed() (int64, time.Time) {
+	trailer := a.stream.Trailer()
+	count, lastTs := trailer.Get(proto.AppendStreamCountTrailer), trailer.Get(proto.AppendStreamLastTsTrailer)
+	if len(count) == 0 || len(lastTs) == 0 {
+		return 0, time.Time{}
+	}
+	n, err := strconv.ParseInt(count[0], 10, 64)
+	if err != nil || n == 0 {
+		return 0, time.Time{}
+	}
+	ts, err := strconv.ParseInt(lastTs[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	return n, time.Unix(0, ts)
+}
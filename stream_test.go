@@ -0,0 +1,99 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/server"
+)
+
+// sendRows sends rows with the timestamps from+1 to from+n, stopping at io.EOF when the server aborted the stream
+func sendRows(t *testing.T, stream *dbsdk.AppendStream, from, n int, data []byte) {
+	t.Helper()
+	for i := from + 1; i <= from+n; i++ {
+		err := stream.Send(time.Unix(0, int64(i)), data)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+}
+
+func TestAppendStream(t *testing.T) {
+	// more rows than the server stores at once
+	const rows = 2500
+
+	srv, client := dbsdktest.Start(t)
+	stream, err := client.AppendStream(context.Background(), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendRows(t, stream, 0, rows, []byte("row"))
+
+	count, lastTs, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if count != rows || !lastTs.Equal(time.Unix(0, rows)) {
+		t.Fatalf("got %d rows up to %d, want %d up to %d", count, lastTs.UnixNano(), rows, rows)
+	}
+	if n := len(srv.Rows("t")); n != rows {
+		t.Fatalf("got %d rows stored, want %d", n, rows)
+	}
+}
+
+func TestAppendStreamServerError(t *testing.T) {
+	// the bad row comes after a full batch and part of the next one
+	const good = 1500
+
+	srv, client := startWith(t, server.WithMaxRowSize(4))
+	stream, err := client.AppendStream(context.Background(), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendRows(t, stream, 0, good, []byte("row"))
+	sendRows(t, stream, good, 1, []byte("too long"))
+	sendRows(t, stream, good+1, 10, []byte("row"))
+
+	count, lastTs, err := stream.CloseAndRecv()
+	if !errors.Is(err, dbsdk.ErrInvalidArgument) {
+		t.Fatalf("got %v, want ErrInvalidArgument", err)
+	}
+	var sdkErr *dbsdk.Error
+	if !errors.As(err, &sdkErr) || sdkErr.Method != "AppendStream" || sdkErr.Table != "t" {
+		t.Fatalf("got %#v, want an AppendStream error on t", err)
+	}
+	if count != good || !lastTs.Equal(time.Unix(0, good)) {
+		t.Fatalf("got %d rows up to %d, want %d up to %d", count, lastTs.UnixNano(), good, good)
+	}
+	if n := len(srv.Rows("t")); n != good {
+		t.Fatalf("got %d rows stored, want the %d before the error", n, good)
+	}
+}
+
+func TestAppendStreamErrorBeforeAnyRow(t *testing.T) {
+	srv, client := startWith(t, server.WithMaxRowSize(4))
+	stream, err := client.AppendStream(context.Background(), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendRows(t, stream, 0, 1, []byte("too long"))
+
+	count, lastTs, err := stream.CloseAndRecv()
+	if !errors.Is(err, dbsdk.ErrInvalidArgument) {
+		t.Fatalf("got %v, want ErrInvalidArgument", err)
+	}
+	if count != 0 || !lastTs.IsZero() {
+		t.Fatalf("got %d rows up to %v, want none", count, lastTs)
+	}
+	if n := len(srv.Rows("t")); n != 0 {
+		t.Fatalf("got %d rows stored, want none", n)
+	}
+}
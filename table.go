@@ -0,0 +1,97 @@
+package dbsdk
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a row of a Table decoded to its value
+type Record[T any] struct {
+	Ts    time.Time
+	Value T
+	// Err is set when the row's data could not be decoded
+	Err error
+}
+
+// Table reads and writes values of type T to a table, converting them with a Codec
+type Table[T any] struct {
+	client *AppendDbSDKClient
+	name   string
+	codec  Codec[T]
+}
+
+// NewTable creates a typed view of the named table
+func NewTable[T any](client *AppendDbSDKClient, name string, codec Codec[T]) *Table[T] {
+	return &Table[T]{client: client, name: name, codec: codec}
+}
+
+// Name returns the name of the table
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+// Append encodes v and writes it as a new row
+func (t *Table[T]) Append(ctx context.Context, ts time.Time, v T) error {
+	dat, err := t.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return t.client.AppendContext(ctx, t.name, ts, dat)
+}
+
+// Query returns the decoded rows that are between start and stop inclusive
+// Rows that fail to decode are returned with their Err set
+func (t *Table[T]) Query(ctx context.Context, start, stop time.Time) ([]Record[T], error) {
+	rows, err := t.client.QueryContext(ctx, t.name, start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record[T], len(rows))
+	for i, row := range rows {
+		records[i] = t.decode(row.Ts, row.Data)
+	}
+	return records, nil
+}
+
+// Scan streams the decoded rows that are between start and stop inclusive
+func (t *Table[T]) Scan(ctx context.Context, start, stop time.Time) *RecordIterator[T] {
+	return &RecordIterator[T]{table: t, rows: t.client.Scan(ctx, t.name, start, stop)}
+}
+
+func (t *Table[T]) decode(ts int64, dat []byte) Record[T] {
+	v, err := t.codec.Decode(dat)
+	return Record[T]{Ts: time.Unix(0, ts), Value: v, Err: err}
+}
+
+// RecordIterator walks the decoded rows of a Table scan, see RowIterator
+type RecordIterator[T any] struct {
+	table  *Table[T]
+	rows   *RowIterator
+	record Record[T]
+}
+
+// Next advances to the next record, it returns false when the scan is finished or failed
+func (it *RecordIterator[T]) Next() bool {
+	if !it.rows.Next() {
+		return false
+	}
+	row := it.rows.Row()
+	it.record = it.table.decode(row.Ts, row.Data)
+	return true
+}
+
+// Record returns the current record, check its Err for decode failures
+func (it *RecordIterator[T]) Record() Record[T] {
+	return it.record
+}
+
+// Err returns the error that stopped the scan, if any
+func (it *RecordIterator[T]) Err() error {
+	return it.rows.Err()
+}
+
+// Close stops the scan early
+func (it *RecordIterator[T]) Close() {
+	it.rows.Close()
+}
@@ -0,0 +1,115 @@
+package dbsdk_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type reading struct {
+	Sensor string
+	Temp   float64
+	Tags   []string
+}
+
+func roundTrip[T any](t *testing.T, codec dbsdk.Codec[T], values []T, equal func(a, b T) bool) {
+	t.Helper()
+	_, client := dbsdktest.Start(t)
+	table := dbsdk.NewTable[T](client, "t", codec)
+	for i, v := range values {
+		if err := table.Append(context.Background(), time.Unix(0, int64(i+1)), v); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	records, err := table.Query(context.Background(), time.Unix(0, 0), time.Unix(0, int64(len(values))))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(records) != len(values) {
+		t.Fatalf("query returned %d records, want %d", len(records), len(values))
+	}
+	for i, r := range records {
+		if r.Err != nil || !r.Ts.Equal(time.Unix(0, int64(i+1))) || !equal(r.Value, values[i]) {
+			t.Fatalf("query record %d: %+v, want %+v", i, r, values[i])
+		}
+	}
+
+	it := table.Scan(context.Background(), time.Unix(0, 0), time.Unix(0, int64(len(values))))
+	defer it.Close()
+	n := 0
+	for ; it.Next(); n++ {
+		if r := it.Record(); !equal(r.Value, values[n]) {
+			t.Fatalf("scan record %d: %+v, want %+v", n, r, values[n])
+		}
+	}
+	if err := it.Err(); err != nil || n != len(values) {
+		t.Fatalf("scan returned %d records: %v", n, err)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	readings := []reading{{Sensor: "a", Temp: 21.5, Tags: []string{"x"}}, {Sensor: "b", Temp: -3}, {}}
+	deepEqual := func(a, b reading) bool { return reflect.DeepEqual(a, b) }
+
+	t.Run("json", func(t *testing.T) {
+		roundTrip[reading](t, dbsdk.JSONCodec[reading]{}, readings, deepEqual)
+	})
+	t.Run("gob", func(t *testing.T) {
+		roundTrip[reading](t, dbsdk.GobCodec[reading]{}, readings, deepEqual)
+	})
+	t.Run("proto", func(t *testing.T) {
+		tuples := []*proto.DBTuple{{Ts: 1, Data: []byte("a")}, {Ts: -5}, {}}
+		equal := func(a, b *proto.DBTuple) bool { return protobuf.Equal(a, b) }
+		roundTrip[*proto.DBTuple](t, dbsdk.ProtoCodec[*proto.DBTuple]{}, tuples, equal)
+	})
+}
+
+func TestScanDecodeError(t *testing.T) {
+	_, client := dbsdktest.Start(t)
+	table := dbsdk.NewTable[reading](client, "t", dbsdk.JSONCodec[reading]{})
+	if err := table.Append(context.Background(), time.Unix(0, 1), reading{Sensor: "a"}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := client.Append("t", time.Unix(0, 2), []byte("not json")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := table.Append(context.Background(), time.Unix(0, 3), reading{Sensor: "c"}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	// the bad row is reported on its record and the scan goes on
+	it := table.Scan(context.Background(), time.Unix(0, 0), time.Unix(0, 3))
+	defer it.Close()
+	var scanned []dbsdk.Record[reading]
+	for it.Next() {
+		scanned = append(scanned, it.Record())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(scanned) != 3 || scanned[0].Value.Sensor != "a" || scanned[2].Value.Sensor != "c" {
+		t.Fatalf("got records %+v, want all 3", scanned)
+	}
+	var syntax *json.SyntaxError
+	if scanned[0].Err != nil || !errors.As(scanned[1].Err, &syntax) || scanned[2].Err != nil {
+		t.Fatalf("got errors %v, %v, %v, want the decode error on the middle record", scanned[0].Err, scanned[1].Err, scanned[2].Err)
+	}
+
+	// Query keeps the bad row, with its error
+	records, err := table.Query(context.Background(), time.Unix(0, 0), time.Unix(0, 3))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(records) != 3 || records[0].Err != nil || records[1].Err == nil || records[2].Err != nil {
+		t.Fatalf("got %+v", records)
+	}
+}
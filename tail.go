@@ -0,0 +1,152 @@
+package dbsdk
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/r-coffee/db-append-only-sdk/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tailMinBackoff = 100 * time.Millisecond
+	tailMaxBackoff = 5 * time.Second
+)
+
+// TailIterator follows the rows of a table as they are appended
+// When the stream breaks it reconnects and resumes after the last row it returned
+//
+//	t := client.Tail(ctx, table, from)
+//	defer t.Close()
+//	for t.Next() {
+//		row := t.Row()
+//	}
+type TailIterator struct {
+	client *AppendDbSDKClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	table  string
+	stream proto.DBService_SubscribeClient
+
+	// resume point: the newest timestamp returned and how many rows with it were returned
+	from int64
+	seen int
+	skip int
+
+	backoff time.Duration
+	row     *proto.DBTuple
+	err     error
+	done    bool
+	closed  int32
+}
+
+// Tail replays the rows of a table at or after fromTs then keeps returning new rows
+// as they are appended, until ctx is done or Close is called
+// Rows appended with a timestamp older than the newest one returned are delivered
+// while connected but are not replayed after a reconnect
+func (s *AppendDbSDKClient) Tail(ctx context.Context, table string, fromTs time.Time) *TailIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	return &TailIterator{
+		client:  s,
+		ctx:     ctx,
+		cancel:  cancel,
+		table:   table,
+		from:    fromTs.UnixNano(),
+		backoff: tailMinBackoff,
+	}
+}
+
+// Next waits for the next row, it returns false once the tail is stopped or failed
+func (t *TailIterator) Next() bool {
+	for !t.done {
+		if t.stream == nil {
+			stream, err := t.client.stub.Subscribe(t.ctx, &proto.SubscribeRequest{Table: t.table, FromTs: t.from})
+			if err != nil {
+				t.retry(err)
+				continue
+			}
+			t.stream = stream
+			t.skip = t.seen
+		}
+
+		row, err := t.stream.Recv()
+		if err != nil {
+			t.stream = nil
+			t.retry(err)
+			continue
+		}
+		t.backoff = tailMinBackoff
+
+		// drop the rows a previous connection already returned
+		if t.skip > 0 && row.Ts == t.from {
+			t.skip--
+			continue
+		}
+		t.skip = 0
+
+		if row.Ts == t.from {
+			t.seen++
+		} else if row.Ts > t.from {
+			t.from, t.seen = row.Ts, 1
+		}
+
+		t.row = row
+		return true
+	}
+	return false
+}
+
+// retry waits before reconnecting, or stops the tail when err can't be recovered from
+func (t *TailIterator) retry(err error) {
+	if t.ctx.Err() != nil {
+		t.stop(t.ctx.Err())
+		return
+	}
+	if err != io.EOF && status.Code(err) != codes.Unavailable {
+		t.stop(err)
+		return
+	}
+
+	timer := time.NewTimer(t.backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.ctx.Done():
+		t.stop(t.ctx.Err())
+		return
+	}
+
+	t.backoff *= 2
+	if t.backoff > tailMaxBackoff {
+		t.backoff = tailMaxBackoff
+	}
+}
+
+func (t *TailIterator) stop(err error) {
+	if atomic.LoadInt32(&t.closed) == 0 {
+		t.err = wrapErr("Subscribe", t.table, err)
+	}
+	t.done = true
+	t.row = nil
+	t.cancel()
+}
+
+// Row returns the current row
+func (t *TailIterator) Row() *proto.DBTuple {
+	return t.row
+}
+
+// Err returns the error that stopped the tail, if any
+// Stopping the tail with Close is not an error
+func (t *TailIterator) Err() error {
+	return t.err
+}
+
+// Close stops the tail, it is safe to call from another goroutine to interrupt Next
+func (t *TailIterator) Close() {
+	atomic.StoreInt32(&t.closed, 1)
+	t.cancel()
+}
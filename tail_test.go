@@ -0,0 +1,61 @@
+package dbsdk_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTailReconnect(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	rows := func(data ...string) []dbsdk.Row {
+		ts := map[byte]int64{'1': 1, '2': 2, '3': 3}
+		var batch []dbsdk.Row
+		for _, d := range data {
+			batch = append(batch, dbsdk.Row{Ts: time.Unix(0, ts[d[0]]), Data: []byte(d)})
+		}
+		return batch
+	}
+	if err := client.AppendBatch(context.Background(), "t", rows("1", "2a", "2b")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tail := client.Tail(ctx, "t", time.Unix(0, 0))
+	defer tail.Close()
+
+	var got []string
+	next := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if !tail.Next() {
+				t.Fatalf("tail stopped after %q: %v", got, tail.Err())
+			}
+			got = append(got, string(tail.Row().Data))
+		}
+	}
+	next(3)
+
+	// the stream breaks when the next rows are sent, the reconnect replays from ts 2
+	// and has to skip the two rows with it that were already returned
+	srv.FailNext("Subscribe", 1, status.Error(codes.Unavailable, "restarting"))
+	if err := client.AppendBatch(context.Background(), "t", rows("2c", "3a", "3b")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	next(3)
+
+	want := []string{"1", "2a", "2b", "2c", "3a", "3b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if n := srv.Calls("Subscribe"); n != 2 {
+		t.Fatalf("got %d subscribe calls, want a reconnect", n)
+	}
+}
@@ -0,0 +1,291 @@
+package dbsdk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriterClosed is returned when using a Writer after Close
+var ErrWriterClosed = errors.New("writer is closed")
+
+// WriterOption configures a Writer
+type WriterOption func(*writerOptions)
+
+type writerOptions struct {
+	maxRows    int
+	maxBytes   int
+	interval   time.Duration
+	bufferSize int
+	onError    func(error, []Row)
+}
+
+func defaultWriterOptions() writerOptions {
+	return writerOptions{
+		maxRows:    500,
+		maxBytes:   1 << 20,
+		interval:   time.Second,
+		bufferSize: 1024,
+	}
+}
+
+// WithFlushCount flushes the buffer once it holds n rows, n <= 0 turns the limit off
+func WithFlushCount(n int) WriterOption {
+	return func(o *writerOptions) {
+		o.maxRows = n
+	}
+}
+
+// WithFlushSize flushes the buffer once the rows it holds add up to n bytes of data, n <= 0 turns the limit off
+func WithFlushSize(n int) WriterOption {
+	return func(o *writerOptions) {
+		o.maxBytes = n
+	}
+}
+
+// WithFlushInterval flushes the buffer every d, d <= 0 turns timed flushes off
+func WithFlushInterval(d time.Duration) WriterOption {
+	return func(o *writerOptions) {
+		o.interval = d
+	}
+}
+
+// WithBufferSize sets how many rows Write can queue before it blocks, n <= 0 makes Write wait for the background goroutine
+func WithBufferSize(n int) WriterOption {
+	return func(o *writerOptions) {
+		o.bufferSize = n
+	}
+}
+
+// WithErrorHandler is called with the error and the dropped rows when a background flush fails
+// Without a handler the rows are dropped all the same and the error is returned by the next call to Flush or Close
+func WithErrorHandler(fn func(err error, rows []Row)) WriterOption {
+	return func(o *writerOptions) {
+		o.onError = fn
+	}
+}
+
+// Writer buffers rows and appends them to a table in batches from a background goroutine
+type Writer struct {
+	client *AppendDbSDKClient
+	table  string
+	opts   writerOptions
+
+	rows    chan Row
+	flushes chan flushRequest
+	done    chan struct{}
+
+	// closing wakes the Writes blocked on a full buffer so Close can take mu
+	closing     chan struct{}
+	closingOnce sync.Once
+
+	mu      sync.RWMutex
+	closed  bool
+	lastErr error
+	exitErr error
+	// exited is set once a Close returned the outcome of the final flush
+	exited bool
+}
+
+type flushRequest struct {
+	ctx    context.Context
+	result chan error
+}
+
+// NewWriter creates a Writer that appends to table
+// Close must be called to flush the remaining rows and stop the background goroutine
+func (s *AppendDbSDKClient) NewWriter(table string, opts ...WriterOption) *Writer {
+	o := defaultWriterOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.bufferSize < 0 {
+		o.bufferSize = 0
+	}
+
+	w := &Writer{
+		client:  s,
+		table:   table,
+		opts:    o,
+		rows:    make(chan Row, o.bufferSize),
+		flushes: make(chan flushRequest),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a row, blocking while the buffer is full
+// A Write blocked when Close is called returns ErrWriterClosed
+// dat must not be modified after Write returns
+func (w *Writer) Write(ctx context.Context, ts time.Time, dat []byte) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.rows <- Row{Ts: ts, Data: dat}:
+		return nil
+	case <-w.closing:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush appends every row queued before the call
+// When the append fails its rows are dropped rather than kept for the next flush,
+// the error handler only sees the rows of background flushes
+func (w *Writer) Flush(ctx context.Context) error {
+	w.mu.RLock()
+	closed := w.closed
+	w.mu.RUnlock()
+	if closed {
+		return ErrWriterClosed
+	}
+
+	req := flushRequest{ctx: ctx, result: make(chan error, 1)}
+	select {
+	case w.flushes <- req:
+	case <-w.done:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the remaining rows and stops the writer
+// When ctx ends before the final flush does Close returns ctx.Err() and the flush goes on,
+// call Close again to wait for it and get its error. Once that is returned Close returns ErrWriterClosed
+func (w *Writer) Close(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	w.closingOnce.Do(func() { close(w.closing) })
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.rows)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exited {
+		return ErrWriterClosed
+	}
+	w.exited = true
+	return w.exitErr
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	// a nil channel never fires, so without an interval there are no timed flushes
+	var tick <-chan time.Time
+	if w.opts.interval > 0 {
+		ticker := time.NewTicker(w.opts.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var batch []Row
+	size := 0
+	add := func(row Row) {
+		batch = append(batch, row)
+		size += len(row.Data)
+	}
+	flush := func(ctx context.Context, background bool) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows := batch
+		batch, size = nil, 0
+
+		// a key on the caller's ctx would make every batch of a flush look like a retry of the first
+		err := w.client.AppendBatch(ContextWithIdempotencyKey(ctx, newIdempotencyKey()), w.table, rows)
+		if err != nil && background && w.opts.onError != nil {
+			w.opts.onError(err, rows)
+			return nil
+		}
+		return err
+	}
+
+	for {
+		select {
+		case row, ok := <-w.rows:
+			if !ok {
+				w.exitErr = flush(context.Background(), true)
+				if w.exitErr == nil {
+					w.exitErr = w.takeErr()
+				}
+				return
+			}
+			add(row)
+			if w.full(len(batch), size) {
+				w.setErr(flush(context.Background(), true))
+			}
+
+		case <-tick:
+			w.setErr(flush(context.Background(), true))
+
+		case req := <-w.flushes:
+			// pick up the rows queued before the flush was requested, the rows written
+			// since stay queued so busy writers can't hold the flush up
+			var err error
+			for n := len(w.rows); n > 0; n-- {
+				add(<-w.rows)
+				if w.full(len(batch), size) {
+					if flushErr := flush(req.ctx, false); err == nil {
+						err = flushErr
+					}
+				}
+			}
+
+			if flushErr := flush(req.ctx, false); err == nil {
+				err = flushErr
+			}
+			if err == nil {
+				err = w.takeErr()
+			}
+			req.result <- err
+		}
+	}
+}
+
+// full reports whether a batch has reached one of the flush thresholds
+func (w *Writer) full(rows, size int) bool {
+	return (w.opts.maxRows > 0 && rows >= w.opts.maxRows) || (w.opts.maxBytes > 0 && size >= w.opts.maxBytes)
+}
+
+func (w *Writer) setErr(err error) {
+	if err != nil {
+		w.lastErr = err
+	}
+}
+
+func (w *Writer) takeErr() error {
+	err := w.lastErr
+	w.lastErr = nil
+	return err
+}
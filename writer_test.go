@@ -0,0 +1,291 @@
+package dbsdk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	dbsdk "github.com/r-coffee/db-append-only-sdk"
+	"github.com/r-coffee/db-append-only-sdk/dbsdktest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func writeRows(t *testing.T, w *dbsdk.Writer, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := w.Write(context.Background(), time.Unix(0, int64(i+1)), []byte(fmt.Sprintf("row %d", i+1))); err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestWriterFlushCount(t *testing.T) {
+	tests := []struct {
+		count, rows, batches int
+	}{
+		{count: 3, rows: 7, batches: 3},
+		{count: 5, rows: 5, batches: 1},
+		{count: 0, rows: 7, batches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("count=%d/rows=%d", tt.count, tt.rows), func(t *testing.T) {
+			srv, client := dbsdktest.Start(t)
+			w := client.NewWriter("t", dbsdk.WithFlushCount(tt.count), dbsdk.WithFlushInterval(0))
+			writeRows(t, w, tt.rows)
+			if err := w.Flush(context.Background()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+
+			rows := srv.Rows("t")
+			if len(rows) != tt.rows {
+				t.Fatalf("got %d rows, want %d", len(rows), tt.rows)
+			}
+			for i, row := range rows {
+				if row.Ts != int64(i+1) {
+					t.Fatalf("row %d has ts %d", i, row.Ts)
+				}
+			}
+			if n := srv.Calls("BatchAppend"); n != tt.batches {
+				t.Fatalf("got %d batches, want %d", n, tt.batches)
+			}
+			if err := w.Close(context.Background()); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	w := client.NewWriter("t", dbsdk.WithFlushInterval(10*time.Millisecond))
+	defer w.Close(context.Background())
+	writeRows(t, w, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.Rows("t")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the row was never flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterFlushWhileWriting(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	w := client.NewWriter("t", dbsdk.WithFlushCount(100), dbsdk.WithFlushInterval(0))
+	defer w.Close(context.Background())
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := w.Write(context.Background(), time.Unix(0, 1), []byte("busy")); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	if err := w.Write(context.Background(), time.Unix(0, 2), []byte("before flush")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("flush while writing: %v", err)
+	}
+
+	found := false
+	for _, row := range srv.Rows("t") {
+		found = found || string(row.Data) == "before flush"
+	}
+	if !found {
+		t.Fatal("the row written before Flush wasn't appended")
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	w := client.NewWriter("t", dbsdk.WithFlushInterval(0))
+	writeRows(t, w, 4)
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if n := len(srv.Rows("t")); n != 4 {
+		t.Fatalf("got %d rows, want 4", n)
+	}
+
+	if err := w.Write(context.Background(), time.Unix(0, 5), nil); !errors.Is(err, dbsdk.ErrWriterClosed) {
+		t.Fatalf("write after close: got %v", err)
+	}
+	if err := w.Flush(context.Background()); !errors.Is(err, dbsdk.ErrWriterClosed) {
+		t.Fatalf("flush after close: got %v", err)
+	}
+	if err := w.Close(context.Background()); !errors.Is(err, dbsdk.ErrWriterClosed) {
+		t.Fatalf("second close: got %v", err)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	down := status.Error(codes.Unavailable, "down")
+
+	t.Run("returned", func(t *testing.T) {
+		srv, client := dbsdktest.Start(t)
+		w := client.NewWriter("t", dbsdk.WithFlushCount(2), dbsdk.WithFlushInterval(0))
+		srv.FailNext("BatchAppend", 1, down)
+		writeRows(t, w, 2)
+
+		// the failed background flush is reported by the next Flush, once
+		if err := w.Flush(context.Background()); !errors.Is(err, dbsdk.ErrUnavailable) {
+			t.Fatalf("flush: got %v, want ErrUnavailable", err)
+		}
+		if err := w.Flush(context.Background()); err != nil {
+			t.Fatalf("second flush: %v", err)
+		}
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		srv, client := dbsdktest.Start(t)
+		var mu sync.Mutex
+		var dropped []dbsdk.Row
+		w := client.NewWriter("t", dbsdk.WithFlushCount(2), dbsdk.WithFlushInterval(0), dbsdk.WithErrorHandler(func(err error, rows []dbsdk.Row) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = append(dropped, rows...)
+		}))
+		srv.FailNext("BatchAppend", 1, down)
+		writeRows(t, w, 3)
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(dropped) != 2 {
+			t.Fatalf("got %d dropped rows, want 2", len(dropped))
+		}
+		if n := len(srv.Rows("t")); n != 1 {
+			t.Fatalf("got %d rows, want 1", n)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		srv, client := dbsdktest.Start(t)
+		w := client.NewWriter("t", dbsdk.WithFlushInterval(0), dbsdk.WithErrorHandler(func(error, []dbsdk.Row) {
+			t.Error("the handler only sees background flushes")
+		}))
+		writeRows(t, w, 3)
+		srv.FailNext("BatchAppend", 1, down)
+		if err := w.Flush(context.Background()); !errors.Is(err, dbsdk.ErrUnavailable) {
+			t.Fatalf("flush: got %v, want ErrUnavailable", err)
+		}
+		w.Close(context.Background())
+	})
+}
+
+func TestWriterCloseWhileWriteBlocked(t *testing.T) {
+	srv, client := dbsdktest.Start(t)
+	srv.SetLatency("BatchAppend", 500*time.Millisecond)
+	w := client.NewWriter("t", dbsdk.WithFlushCount(1), dbsdk.WithFlushInterval(0), dbsdk.WithBufferSize(0))
+
+	// the first row keeps the background goroutine in a slow flush, the second blocks
+	writeRows(t, w, 1)
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- w.Write(context.Background(), time.Unix(0, 2), nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	if err := w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close: got %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(began); d > 300*time.Millisecond {
+		t.Fatalf("close took %s", d)
+	}
+	if err := <-blocked; !errors.Is(err, dbsdk.ErrWriterClosed) {
+		t.Fatalf("blocked write: got %v, want ErrWriterClosed", err)
+	}
+
+	// a second Close waits for the final flush the first one gave up on
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if err := w.Close(context.Background()); !errors.Is(err, dbsdk.ErrWriterClosed) {
+		t.Fatalf("third close: got %v", err)
+	}
+}
+
+func TestWriterFailedFlush(t *testing.T) {
+	down := status.Error(codes.Unavailable, "down")
+
+	t.Run("flush", func(t *testing.T) {
+		srv, client := dbsdktest.Start(t)
+		w := client.NewWriter("t", dbsdk.WithFlushInterval(0))
+		writeRows(t, w, 3)
+		srv.FailNext("BatchAppend", 1, down)
+		if err := w.Flush(context.Background()); !errors.Is(err, dbsdk.ErrUnavailable) {
+			t.Fatalf("flush: got %v, want ErrUnavailable", err)
+		}
+
+		// the rows of the failed flush are not sent again
+		if err := w.Write(context.Background(), time.Unix(0, 4), []byte("row 4")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		rows := srv.Rows("t")
+		if len(rows) != 1 || rows[0].Ts != 4 {
+			t.Fatalf("got %d rows, want only the one written after the failure", len(rows))
+		}
+		if n := srv.Calls("BatchAppend"); n != 2 {
+			t.Fatalf("got %d appends, want 2", n)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		srv, client := dbsdktest.Start(t)
+		w := client.NewWriter("t", dbsdk.WithFlushInterval(0))
+		writeRows(t, w, 3)
+		srv.SetLatency("BatchAppend", 200*time.Millisecond)
+		srv.FailNext("BatchAppend", 1, down)
+
+		// Close gives up before the final flush fails, the next Close reports it
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("close: got %v, want DeadlineExceeded", err)
+		}
+		if err := w.Close(context.Background()); !errors.Is(err, dbsdk.ErrUnavailable) {
+			t.Fatalf("second close: got %v, want ErrUnavailable", err)
+		}
+		if err := w.Close(context.Background()); !errors.Is(err, dbsdk.ErrWriterClosed) {
+			t.Fatalf("third close: got %v, want ErrWriterClosed", err)
+		}
+		if n := len(srv.Rows("t")); n != 0 {
+			t.Fatalf("got %d rows, want 0", n)
+		}
+	})
+}